@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerHubHost is the registry host of images whose names don't include one
+// and dockerHubServer the key under which Docker stores its credentials.
+const dockerHubHost = "docker.io"
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// credentialsNotFound is the message printed by a credential helper that has
+// no credentials for a server.
+const credentialsNotFound = "credentials not found in native keychain"
+
+// DockerConfig holds the parts of a Docker client configuration file that
+// describe how to authenticate with registries.
+type DockerConfig struct {
+	Auths       map[string]DockerConfigAuth `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+// DockerConfigAuth is a single entry in the auths section of a Docker client
+// configuration file.
+type DockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// Credentials picks the credentials to pull an image with from the Docker
+// client configuration and any given with --registry-auth, which take
+// precedence.
+type Credentials struct {
+	Config    DockerConfig
+	Overrides map[string]docker.AuthConfiguration
+}
+
+// runCredentialHelper runs the named Docker credential helper to get the
+// credentials for a server and returns its output.
+var runCredentialHelper = func(helper string, server string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("docker-credential-"+helper, "get")
+	command.Stdin = strings.NewReader(server)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), credentialsNotFound) {
+			return nil, errCredentialsNotFound
+		}
+		return nil, fmt.Errorf("credential helper docker-credential-%s failed: %s", helper, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+var errCredentialsNotFound = fmt.Errorf(credentialsNotFound)
+
+var registryHostPattern = regexp.MustCompile(`^(?:localhost|[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)+)(?::[0-9]+)?$`)
+
+// DockerConfigPath returns the path of the Docker client configuration file,
+// which is in $DOCKER_CONFIG if set or ~/.docker otherwise.
+func DockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return filepath.Join(HomeDir(), ".docker", "config.json")
+}
+
+// LoadCredentials reads the Docker client configuration file, if there is
+// one, and parses the --registry-auth overrides. An override without a host
+// only applies to defaultHost, the registry of --image or the first mirror,
+// so that credentials meant for a private registry aren't sent to Docker Hub
+// or any other registry an image is pulled from. An override for the host
+// itself takes precedence, and without a defaultHost a hostless override is
+// an error.
+func LoadCredentials(overrides []string, defaultHost string) (*Credentials, error) {
+	credentials := &Credentials{Overrides: map[string]docker.AuthConfiguration{}}
+
+	filename := DockerConfigPath()
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(content, &credentials.Config); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+
+	var hostless *docker.AuthConfiguration
+	for _, override := range overrides {
+		host, auth, err := ParseRegistryAuth(override)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			if defaultHost == "" {
+				return nil, fmt.Errorf("registry auth without a host needs --image or --mirror to apply to, expected HOST=USER:PASSWORD")
+			}
+			hostless = &auth
+			continue
+		}
+		credentials.Overrides[host] = auth
+	}
+	if _, ok := credentials.Overrides[defaultHost]; !ok && hostless != nil {
+		credentials.Overrides[defaultHost] = *hostless
+	}
+	return credentials, nil
+}
+
+// ParseRegistryAuth takes a --registry-auth value in the form
+// [HOST=]USER:PASSWORD, where USER:PASSWORD may also be base64 encoded as in
+// a Docker client configuration file, and returns the host it applies to and
+// the credentials. The value itself is never included in the error.
+func ParseRegistryAuth(value string) (string, docker.AuthConfiguration, error) {
+	host, value := splitRegistryAuthHost(value)
+	if host != "" {
+		host = NormaliseRegistryHost(host)
+	}
+
+	username, password, ok := splitUserPassword(value)
+	if !ok {
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			username, password, ok = splitUserPassword(string(decoded))
+		}
+	}
+	if !ok {
+		return "", docker.AuthConfiguration{}, fmt.Errorf("invalid registry auth, expected [HOST=]USER:PASSWORD")
+	}
+	return host, docker.AuthConfiguration{Username: username, Password: password}, nil
+}
+
+// splitRegistryAuthHost splits the host, if there is one, from the
+// credentials in a --registry-auth value.
+func splitRegistryAuthHost(value string) (string, string) {
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 && registryHostPattern.MatchString(parts[0]) {
+		return parts[0], parts[1]
+	}
+	return "", value
+}
+
+func splitUserPassword(value string) (string, string, bool) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// RegistryHost returns the host of the registry an image is pulled from.
+// As with Docker, the first component of the name is a host if it contains
+// a dot or a colon or is localhost, otherwise the image is on Docker Hub.
+func RegistryHost(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return NormaliseRegistryHost(parts[0])
+	}
+	return dockerHubHost
+}
+
+// NormaliseRegistryHost strips the scheme and path from a registry address
+// as used for the keys of a Docker client configuration file, so that e.g.
+// https://index.docker.io/v1/ becomes docker.io.
+func NormaliseRegistryHost(address string) string {
+	host := address
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubHost
+	}
+	return host
+}
+
+// Lookup returns the credentials to pull an image with, or empty credentials
+// if there are none for its registry.
+func (credentials *Credentials) Lookup(image string) (docker.AuthConfiguration, error) {
+	if credentials == nil {
+		return docker.AuthConfiguration{}, nil
+	}
+	host := RegistryHost(image)
+	server := host
+	if host == dockerHubHost {
+		server = dockerHubServer
+	}
+
+	if auth, ok := credentials.Overrides[host]; ok {
+		auth.ServerAddress = server
+		return auth, nil
+	}
+
+	helper := credentials.Config.CredsStore
+	if credHelper, ok := credentials.Config.CredHelpers[host]; ok {
+		helper = credHelper
+	} else if credHelper, ok := credentials.Config.CredHelpers[server]; ok {
+		helper = credHelper
+	}
+	if helper != "" {
+		auth, err := helperCredentials(helper, server)
+		if err != errCredentialsNotFound {
+			return auth, err
+		}
+	}
+
+	for address, entry := range credentials.Config.Auths {
+		if NormaliseRegistryHost(address) == host {
+			return entry.authConfiguration(server)
+		}
+	}
+	return docker.AuthConfiguration{}, nil
+}
+
+func helperCredentials(helper string, server string) (docker.AuthConfiguration, error) {
+	output, err := runCredentialHelper(helper, server)
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+
+	var response struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return docker.AuthConfiguration{}, fmt.Errorf("credential helper docker-credential-%s returned an invalid response", helper)
+	}
+
+	auth := docker.AuthConfiguration{ServerAddress: server}
+	if response.Username == "<token>" {
+		auth.IdentityToken = response.Secret
+	} else {
+		auth.Username, auth.Password = response.Username, response.Secret
+	}
+	return auth, nil
+}
+
+func (entry DockerConfigAuth) authConfiguration(server string) (docker.AuthConfiguration, error) {
+	auth := docker.AuthConfiguration{
+		Username:      entry.Username,
+		Password:      entry.Password,
+		ServerAddress: server,
+		IdentityToken: entry.IdentityToken,
+	}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return auth, fmt.Errorf("invalid auth entry for %s in %s", server, DockerConfigPath())
+		}
+		username, password, ok := splitUserPassword(string(decoded))
+		if !ok {
+			return auth, fmt.Errorf("invalid auth entry for %s in %s", server, DockerConfigPath())
+		}
+		auth.Username, auth.Password = username, password
+	}
+	return auth, nil
+}
+
+// MaskRegistryAuth hides the credentials in a --registry-auth value so that
+// it can be displayed, keeping only the host if one is given.
+func MaskRegistryAuth(value string) string {
+	if host, _ := splitRegistryAuthHost(value); host != "" {
+		return host + "=********"
+	}
+	return "********"
+}
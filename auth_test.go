@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestRegistryHost(t *testing.T) {
+	cases := []struct {
+		image    string
+		wantHost string
+	}{
+		{"dexec/lang-c", "docker.io"},
+		{"ubuntu", "docker.io"},
+		{"docker.io/dexec/lang-c", "docker.io"},
+		{"index.docker.io/dexec/lang-c", "docker.io"},
+		{"registry.corp.local/dexec/lang-c", "registry.corp.local"},
+		{"registry.corp.local:5000/lang-c", "registry.corp.local:5000"},
+		{"localhost/lang-c", "localhost"},
+	}
+	for _, c := range cases {
+		if gotHost := RegistryHost(c.image); gotHost != c.wantHost {
+			t.Errorf("RegistryHost(%q) %q != %q", c.image, gotHost, c.wantHost)
+		}
+	}
+}
+
+func TestParseRegistryAuth(t *testing.T) {
+	cases := []struct {
+		value        string
+		wantHost     string
+		wantUsername string
+		wantPassword string
+	}{
+		{"user:secret", "", "user", "secret"},
+		{"user:se=cr:et", "", "user", "se=cr:et"},
+		{"registry.corp.local=user:secret", "registry.corp.local", "user", "secret"},
+		{"localhost:5000=user:secret", "localhost:5000", "user", "secret"},
+		{"dXNlcjpzZWNyZXQ=", "", "user", "secret"},
+		{"registry.corp.local=dXNlcjpzZWNyZXQ=", "registry.corp.local", "user", "secret"},
+	}
+	for _, c := range cases {
+		gotHost, gotAuth, err := ParseRegistryAuth(c.value)
+		if err != nil {
+			t.Errorf("ParseRegistryAuth(%q) unexpected error %v", c.value, err)
+		} else if gotHost != c.wantHost {
+			t.Errorf("ParseRegistryAuth(%q) host %q != %q", c.value, gotHost, c.wantHost)
+		} else if gotAuth.Username != c.wantUsername || gotAuth.Password != c.wantPassword {
+			t.Errorf("ParseRegistryAuth(%q) %q:%q != %q:%q", c.value, gotAuth.Username, gotAuth.Password, c.wantUsername, c.wantPassword)
+		}
+	}
+}
+
+func TestParseRegistryAuthErrors(t *testing.T) {
+	for _, value := range []string{"secret", "user:", ":secret", "registry.corp.local=secret"} {
+		_, _, err := ParseRegistryAuth(value)
+		if err == nil {
+			t.Errorf("ParseRegistryAuth(%q) expected an error", value)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("ParseRegistryAuth(%q) error %q includes the credentials", value, err)
+		}
+	}
+}
+
+func TestMaskRegistryAuth(t *testing.T) {
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{"user:secret", "********"},
+		{"registry.corp.local=user:secret", "registry.corp.local=********"},
+		{"user:se=cret", "********"},
+	}
+	for _, c := range cases {
+		if got := MaskRegistryAuth(c.value); got != c.want {
+			t.Errorf("MaskRegistryAuth(%q) %q != %q", c.value, got, c.want)
+		}
+	}
+}
+
+func TestCredentialsLookup(t *testing.T) {
+	defer func(run func(string, string) ([]byte, error)) { runCredentialHelper = run }(runCredentialHelper)
+	runCredentialHelper = func(helper string, server string) ([]byte, error) {
+		switch {
+		case helper == "ecr-login" && server == "123.dkr.ecr.eu-west-1.amazonaws.com":
+			return []byte(`{"ServerURL":"123.dkr.ecr.eu-west-1.amazonaws.com","Username":"AWS","Secret":"ecr-secret"}`), nil
+		case helper == "desktop" && server == dockerHubServer:
+			return []byte(`{"ServerURL":"https://index.docker.io/v1/","Username":"<token>","Secret":"hub-token"}`), nil
+		case helper == "desktop":
+			return nil, errCredentialsNotFound
+		default:
+			return nil, fmt.Errorf("unexpected helper %s for %s", helper, server)
+		}
+	}
+
+	credentials := &Credentials{
+		Config: DockerConfig{
+			Auths: map[string]DockerConfigAuth{
+				"https://registry.corp.local/v1/": {Auth: "dXNlcjpzZWNyZXQ="},
+				"localhost:5000":                  {Username: "local", Password: "pass"},
+			},
+			CredsStore:  "desktop",
+			CredHelpers: map[string]string{"123.dkr.ecr.eu-west-1.amazonaws.com": "ecr-login"},
+		},
+		Overrides: map[string]docker.AuthConfiguration{
+			"override.corp.local": {Username: "cli", Password: "cli-secret"},
+		},
+	}
+
+	cases := []struct {
+		image    string
+		wantAuth docker.AuthConfiguration
+	}{
+		{"dexec/lang-c", docker.AuthConfiguration{IdentityToken: "hub-token", ServerAddress: dockerHubServer}},
+		{"registry.corp.local/dexec/lang-c", docker.AuthConfiguration{Username: "user", Password: "secret", ServerAddress: "registry.corp.local"}},
+		{"localhost:5000/lang-c", docker.AuthConfiguration{Username: "local", Password: "pass", ServerAddress: "localhost:5000"}},
+		{"123.dkr.ecr.eu-west-1.amazonaws.com/lang-c", docker.AuthConfiguration{Username: "AWS", Password: "ecr-secret", ServerAddress: "123.dkr.ecr.eu-west-1.amazonaws.com"}},
+		{"override.corp.local/lang-c", docker.AuthConfiguration{Username: "cli", Password: "cli-secret", ServerAddress: "override.corp.local"}},
+		{"other.corp.local/lang-c", docker.AuthConfiguration{}},
+	}
+	for _, c := range cases {
+		gotAuth, err := credentials.Lookup(c.image)
+		if err != nil {
+			t.Errorf("Lookup(%q) unexpected error %v", c.image, err)
+		} else if gotAuth != c.wantAuth {
+			t.Errorf("Lookup(%q) %+v != %+v", c.image, gotAuth, c.wantAuth)
+		}
+	}
+
+}
+
+func TestLoadCredentialsHostless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	credentials, err := LoadCredentials([]string{"ci:secret", "localhost:5000=local:pass"}, "registry.corp.local")
+	if err != nil {
+		t.Fatalf("LoadCredentials() unexpected error %v", err)
+	}
+	cases := []struct {
+		image    string
+		wantAuth docker.AuthConfiguration
+	}{
+		{"registry.corp.local/dexec/lang-c", docker.AuthConfiguration{Username: "ci", Password: "secret", ServerAddress: "registry.corp.local"}},
+		{"localhost:5000/lang-c", docker.AuthConfiguration{Username: "local", Password: "pass", ServerAddress: "localhost:5000"}},
+		{"dexec/lang-c", docker.AuthConfiguration{}},
+		{"docker.io/library/gcc", docker.AuthConfiguration{}},
+		{"other.corp.local/lang-c", docker.AuthConfiguration{}},
+	}
+	for _, c := range cases {
+		if gotAuth, err := credentials.Lookup(c.image); err != nil || gotAuth != c.wantAuth {
+			t.Errorf("Lookup(%q) %+v, %v != %+v", c.image, gotAuth, err, c.wantAuth)
+		}
+	}
+
+	credentials, err = LoadCredentials([]string{"registry.corp.local=host:secret", "ci:secret"}, "registry.corp.local")
+	if gotAuth, _ := credentials.Lookup("registry.corp.local/lang-c"); err != nil || gotAuth.Username != "host" {
+		t.Errorf("LoadCredentials() hostless override replaced the one for its host: %+v, %v", gotAuth, err)
+	}
+
+	want := "registry auth without a host needs --image or --mirror to apply to, expected HOST=USER:PASSWORD"
+	if _, err := LoadCredentials([]string{"ci:secret"}, ""); err == nil || err.Error() != want {
+		t.Errorf("LoadCredentials() %v != %q", err, want)
+	}
+}
+
+func TestRegistryAuthHost(t *testing.T) {
+	mirrors := []RegistryMirror{{Source: "dexec/", Prefix: "mirror.corp.local/dexec/"}}
+	cases := []struct {
+		options map[OptionType][]string
+		mirrors []RegistryMirror
+		want    string
+	}{
+		{map[OptionType][]string{Image: {"registry.corp.local/toolchains/lang-cpp:2.1"}}, mirrors, "registry.corp.local"},
+		{map[OptionType][]string{Image: {"myorg/lang-cpp"}}, nil, "docker.io"},
+		{map[OptionType][]string{}, mirrors, "mirror.corp.local"},
+		{map[OptionType][]string{}, nil, ""},
+	}
+	for _, c := range cases {
+		if got := registryAuthHost(c.options, c.mirrors); got != c.want {
+			t.Errorf("registryAuthHost(%v, %v) %q != %q", c.options, c.mirrors, got, c.want)
+		}
+	}
+}
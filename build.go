@@ -0,0 +1,411 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// buildDockerfileName is the name the Dockerfile is given in the build
+// context sent to Docker, chosen so as not to clash with a Dockerfile in the
+// context directory.
+const buildDockerfileName = ".dexec.Dockerfile"
+
+// buildImageArg is the build argument that holds the reference of the dexec
+// image being customised, for Dockerfiles that name their own base image
+// with FROM ${DEXEC_IMAGE}.
+const buildImageArg = "DEXEC_IMAGE"
+
+// buildRepositoryTemplate is the repository that customised images are tagged
+// with, named after the dexec image they are built from.
+const buildRepositoryTemplate = "dexec/lang-%s-build"
+
+// buildTagLength is the number of hex digits of the build hash used as the
+// tag of a customised image.
+const buildTagLength = 12
+
+// ImageBuild describes a customised image that is built on top of a dexec
+// image, either from a Dockerfile or from Dockerfile instructions given
+// inline. If the instructions have no FROM line the dexec image is used as
+// the base. Context is the directory whose files are made available to COPY
+// and ADD instructions; if it's empty only the Dockerfile is sent to Docker.
+type ImageBuild struct {
+	Dockerfile   string
+	Instructions string
+	Context      string
+}
+
+// BuildFromOptions returns the build given with --dockerfile, relative to
+// the source directory and with the Dockerfile's directory as its context as
+// for docker build, or the build from the image's registry entry if there
+// isn't one. It returns nil if the image isn't customised.
+func BuildFromOptions(options map[OptionType][]string, image *ContainerImage) *ImageBuild {
+	if len(options[Dockerfile]) > 0 {
+		dockerfile := options[Dockerfile][0]
+		if !filepath.IsAbs(dockerfile) {
+			dockerfile = filepath.Join(RetrievePath(options[TargetDir]), dockerfile)
+		}
+		return &ImageBuild{Dockerfile: dockerfile, Context: filepath.Dir(dockerfile)}
+	}
+	return image.Build
+}
+
+// BuildRepository returns the repository that customised images built from
+// the given dexec image are tagged with, e.g. dexec/lang-cpp-build for
+// dexec/lang-cpp or a mirror of it.
+func BuildRepository(image string) string {
+	return fmt.Sprintf(buildRepositoryTemplate, strings.TrimPrefix(path.Base(image), "lang-"))
+}
+
+// buildIgnoreFilename is the file in the context directory that lists the
+// files to leave out of the build context, as for docker build.
+const buildIgnoreFilename = ".dockerignore"
+
+// buildFile is a file or directory in the build context, named relative to
+// the context directory.
+type buildFile struct {
+	Name string
+	Path string
+	Info os.FileInfo
+}
+
+// buildInstructions returns the Dockerfile instructions of a build, with a
+// FROM line for base added if they have none.
+func buildInstructions(build *ImageBuild, base string) (string, error) {
+	instructions := build.Instructions
+	if build.Dockerfile != "" {
+		content, err := ioutil.ReadFile(build.Dockerfile)
+		if err != nil {
+			return "", err
+		}
+		instructions = string(content)
+	}
+	if !hasFromInstruction(instructions) {
+		instructions = fmt.Sprintf("FROM %s\n%s", base, instructions)
+	}
+	return instructions, nil
+}
+
+// buildContextFiles returns the files and directories in the context
+// directory of a build in lexical order, leaving out those excluded by its
+// .dockerignore file. Only their details are read, not their content.
+func buildContextFiles(build *ImageBuild) ([]buildFile, error) {
+	if build.Context == "" {
+		return nil, nil
+	}
+	ignore, err := ReadBuildIgnore(filepath.Join(build.Context, buildIgnoreFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []buildFile
+	err = filepath.Walk(build.Context, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(build.Context, filename)
+		if err != nil || name == "." || name == buildDockerfileName {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if ignore.Excludes(name) {
+			if info.IsDir() && !ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || info.Mode().IsRegular() {
+			files = append(files, buildFile{Name: name, Path: filename, Info: info})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// WriteBuildContext writes the tar archive that is sent to Docker to build
+// the image from base. The archive holds the Dockerfile, with a FROM line for
+// base added if it has none, followed by the files in the context directory
+// that aren't excluded by its .dockerignore file, in lexical order. Files are
+// streamed rather than read into memory. Timestamps and ownership are left
+// out so that the archive only changes when the content of the inputs does.
+func WriteBuildContext(out io.Writer, build *ImageBuild, base string) error {
+	instructions, err := buildInstructions(build, base)
+	if err != nil {
+		return err
+	}
+	files, err := buildContextFiles(build)
+	if err != nil {
+		return err
+	}
+
+	writer := tar.NewWriter(out)
+	if err := writeTarFile(writer, buildDockerfileName, 0644, []byte(instructions)); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Info.IsDir() {
+			if err := writer.WriteHeader(&tar.Header{Name: file.Name + "/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+				return err
+			}
+			continue
+		}
+		header := &tar.Header{Name: file.Name, Mode: int64(file.Info.Mode().Perm()), Size: file.Info.Size()}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyFile(writer, file.Path); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeTarFile(writer *tar.Writer, name string, mode int64, content []byte) error {
+	if err := writer.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(content))}); err != nil {
+		return err
+	}
+	_, err := writer.Write(content)
+	return err
+}
+
+func copyFile(out io.Writer, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(out, file)
+	return err
+}
+
+// BuildIgnore holds the patterns of a .dockerignore file. As for docker
+// build, a path is excluded if it or one of its parent directories matches
+// the last pattern that applies to it, and patterns starting with '!' are
+// exceptions that include paths again.
+type BuildIgnore struct {
+	patterns []buildIgnorePattern
+}
+
+type buildIgnorePattern struct {
+	pattern   *regexp.Regexp
+	exception bool
+}
+
+// ReadBuildIgnore reads the patterns from a .dockerignore file. A missing
+// file excludes nothing.
+func ReadBuildIgnore(filename string) (*BuildIgnore, error) {
+	ignore := &BuildIgnore{}
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return ignore, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exception := strings.HasPrefix(line, "!")
+		line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+		line = strings.Trim(path.Clean(filepath.ToSlash(line)), "/")
+		if line == "" || line == "." {
+			continue
+		}
+		pattern, err := ignorePatternRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q", filename, line)
+		}
+		ignore.patterns = append(ignore.patterns, buildIgnorePattern{pattern, exception})
+	}
+	return ignore, scanner.Err()
+}
+
+// ignorePatternRegexp converts a .dockerignore pattern to a regular
+// expression, where '**' matches any number of directories, '*' and '?'
+// match within a single path component and character classes are kept.
+func ignorePatternRegexp(pattern string) (*regexp.Regexp, error) {
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expression.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case c == '*':
+			expression.WriteString("[^/]*")
+		case c == '?':
+			expression.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			expression.WriteString(pattern[i : i+end+1])
+			i += end
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
+
+// Excludes reports whether the path, relative to the context directory and
+// separated by slashes, is left out of the build context.
+func (ignore *BuildIgnore) Excludes(name string) bool {
+	excluded := false
+	for _, pattern := range ignore.patterns {
+		if pattern.matches(name) {
+			excluded = !pattern.exception
+		}
+	}
+	return excluded
+}
+
+// HasExceptions reports whether any pattern includes paths again, in which
+// case an excluded directory has to be searched for files to include.
+func (ignore *BuildIgnore) HasExceptions() bool {
+	for _, pattern := range ignore.patterns {
+		if pattern.exception {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the pattern matches the path or one of its parent
+// directories.
+func (pattern buildIgnorePattern) matches(name string) bool {
+	for {
+		if pattern.pattern.MatchString(name) {
+			return true
+		}
+		index := strings.LastIndexByte(name, '/')
+		if index < 0 {
+			return false
+		}
+		name = name[:index]
+	}
+}
+
+// hasFromInstruction reports whether Dockerfile instructions include a FROM
+// line.
+func hasFromInstruction(instructions string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(instructions))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildHash returns the hash that a customised image is tagged with. It
+// covers the Dockerfile, the name, mode and content of each file in the build
+// context and the ID of the base image, so that the image is rebuilt when any
+// of them change. The files are hashed as they are read, without building
+// the context archive.
+func BuildHash(build *ImageBuild, base string, baseID string) (string, error) {
+	instructions, err := buildInstructions(build, base)
+	if err != nil {
+		return "", err
+	}
+	files, err := buildContextFiles(build)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%s", len(instructions), instructions)
+	for _, file := range files {
+		if file.Info.IsDir() {
+			fmt.Fprintf(hash, "\x00%s/", file.Name)
+			continue
+		}
+		fmt.Fprintf(hash, "\x00%s\x00%o\x00%d\x00", file.Name, file.Info.Mode().Perm(), file.Info.Size())
+		if err := copyFile(hash, file.Path); err != nil {
+			return "", err
+		}
+	}
+	fmt.Fprintf(hash, "\x00%s\x00%s", base, baseID)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// BuildImage returns the customised image built from base, building it with
+// the Docker build API unless an image built from the same inputs already
+// exists locally. Build output is written to the progress writer in the pull
+// options, or kept back and included in the error if the build fails.
+func BuildImage(base *ContainerImage, build *ImageBuild, pullOptions PullOptions, client *docker.Client) (*ContainerImage, error) {
+	inspectedBase, err := client.InspectImage(base.Reference())
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := BuildHash(build, base.Reference(), inspectedBase.ID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read build context: %s", err)
+	}
+
+	built := *base
+	built.Image = BuildRepository(base.Image)
+	built.Version = hash[:buildTagLength]
+	built.Digest = ""
+	built.Build = nil
+
+	if _, err := client.InspectImage(built.Reference()); err == nil {
+		return &built, nil
+	} else if err != docker.ErrNoSuchImage {
+		return nil, err
+	}
+
+	// The context is only archived once the image is known to be missing,
+	// and is streamed to Docker as it is written.
+	context, contextWriter := io.Pipe()
+	defer context.Close()
+	go func() {
+		contextWriter.CloseWithError(WriteBuildContext(contextWriter, build, base.Reference()))
+	}()
+
+	var output bytes.Buffer
+	var out io.Writer = &output
+	if pullOptions.Progress != nil {
+		fmt.Fprintf(pullOptions.Progress, "dexec: building %s from %s\n", built.Reference(), base.Reference())
+		out = pullOptions.Progress
+	}
+	err = client.BuildImage(docker.BuildImageOptions{
+		Name:           built.Reference(),
+		Dockerfile:     buildDockerfileName,
+		InputStream:    context,
+		OutputStream:   out,
+		BuildArgs:      []docker.BuildArg{{Name: buildImageArg, Value: base.Reference()}},
+		Labels:         map[string]string{"io.dexec.base": base.Reference()},
+		Platform:       pullOptions.platformFor(base),
+		RmTmpContainer: true,
+	})
+	if err != nil {
+		if output.Len() > 0 {
+			return nil, fmt.Errorf("unable to build %s: %s\n%s", built.Reference(), err, output.String())
+		}
+		return nil, fmt.Errorf("unable to build %s: %s", built.Reference(), err)
+	}
+	return &built, nil
+}
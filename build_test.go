@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestBuildRepository(t *testing.T) {
+	cases := []struct {
+		image string
+		want  string
+	}{
+		{"dexec/lang-cpp", "dexec/lang-cpp-build"},
+		{"registry.corp.local/dexec/lang-cpp", "dexec/lang-cpp-build"},
+		{"example/octave", "dexec/lang-octave-build"},
+	}
+	for _, c := range cases {
+		if got := BuildRepository(c.image); got != c.want {
+			t.Errorf("BuildRepository(%q) %q != %q", c.image, got, c.want)
+		}
+	}
+}
+
+func TestBuildFromOptions(t *testing.T) {
+	registryBuild := &ImageBuild{Instructions: "RUN true\n"}
+	image := &ContainerImage{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2", Build: registryBuild}
+	cases := []struct {
+		options map[OptionType][]string
+		want    *ImageBuild
+	}{
+		{map[OptionType][]string{}, registryBuild},
+		{
+			map[OptionType][]string{TargetDir: {"/src"}, Dockerfile: {"docker/Dockerfile.dexec"}},
+			&ImageBuild{Dockerfile: "/src/docker/Dockerfile.dexec", Context: "/src/docker"},
+		},
+		{
+			map[OptionType][]string{TargetDir: {"/src"}, Dockerfile: {"/opt/Dockerfile"}},
+			&ImageBuild{Dockerfile: "/opt/Dockerfile", Context: "/opt"},
+		},
+	}
+	for _, c := range cases {
+		if got := BuildFromOptions(c.options, image); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("BuildFromOptions(%v) %+v != %+v", c.options, got, c.want)
+		}
+	}
+}
+
+func TestHasFromInstruction(t *testing.T) {
+	cases := []struct {
+		instructions string
+		want         bool
+	}{
+		{"RUN apt-get install -y libboost-dev\n", false},
+		{"# FROM is added by dexec\nRUN true\n", false},
+		{"ARG DEXEC_IMAGE\nFROM ${DEXEC_IMAGE}\nRUN true\n", true},
+		{"  from dexec/lang-cpp:1.0.2\n", true},
+	}
+	for _, c := range cases {
+		if got := hasFromInstruction(c.instructions); got != c.want {
+			t.Errorf("hasFromInstruction(%q) %t != %t", c.instructions, got, c.want)
+		}
+	}
+}
+
+func writeBuildFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWriteBuildContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeBuildFiles(t, dir, map[string]string{
+		"Dockerfile":               "COPY lib /usr/local/lib\n",
+		"lib/libfoo.so":            "foo",
+		".dexec.Dockerfile":        "ignored",
+		".dockerignore":            "# build output\nnode_modules\n*.log\n!keep.log\n",
+		"node_modules/left/out.js": "out",
+		"debug.log":                "out",
+		"keep.log":                 "in",
+	})
+
+	build := &ImageBuild{Dockerfile: filepath.Join(dir, "Dockerfile"), Context: dir}
+	var context bytes.Buffer
+	if err := WriteBuildContext(&context, build, "dexec/lang-cpp:1.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	var dockerfile string
+	reader := tar.NewReader(bytes.NewReader(context.Bytes()))
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+		if header.Name == buildDockerfileName {
+			content, _ := ioutil.ReadAll(reader)
+			dockerfile = string(content)
+		}
+	}
+	wantNames := []string{".dexec.Dockerfile", ".dockerignore", "Dockerfile", "keep.log", "lib/", "lib/libfoo.so"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("WriteBuildContext() entries %q != %q", names, wantNames)
+	}
+	if want := "FROM dexec/lang-cpp:1.0.2\nCOPY lib /usr/local/lib\n"; dockerfile != want {
+		t.Errorf("WriteBuildContext() Dockerfile %q != %q", dockerfile, want)
+	}
+
+	var again bytes.Buffer
+	if err := WriteBuildContext(&again, build, "dexec/lang-cpp:1.0.2"); err != nil || !bytes.Equal(context.Bytes(), again.Bytes()) {
+		t.Errorf("WriteBuildContext() is not reproducible")
+	}
+}
+
+func TestBuildIgnore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeBuildFiles(t, dir, map[string]string{
+		".dockerignore": ".git\n/build/\n**/*.tmp\ndocs/*.md\n!docs/README.md\ndata/[ab].csv\n",
+	})
+
+	ignore, err := ReadBuildIgnore(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{".git", true},
+		{".git/objects/ab", true},
+		{"build/main.o", true},
+		{"src/build/main.o", false},
+		{"x.tmp", true},
+		{"src/deep/x.tmp", true},
+		{"docs/guide.md", true},
+		{"docs/README.md", false},
+		{"docs/api/guide.md", false},
+		{"data/a.csv", true},
+		{"data/c.csv", false},
+		{"main.cpp", false},
+	}
+	for _, c := range cases {
+		if got := ignore.Excludes(c.name); got != c.want {
+			t.Errorf("Excludes(%q) %t != %t", c.name, got, c.want)
+		}
+	}
+	if !ignore.HasExceptions() {
+		t.Errorf("HasExceptions() false with a ! pattern")
+	}
+
+	missing, err := ReadBuildIgnore(filepath.Join(dir, "missing"))
+	if err != nil || missing.Excludes("main.cpp") {
+		t.Errorf("ReadBuildIgnore() of a missing file %v, %v", missing, err)
+	}
+}
+
+func TestBuildHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeBuildFiles(t, dir, map[string]string{"lib/libfoo.so": "foo", ".dockerignore": "*.log\n", "debug.log": "one"})
+
+	build := &ImageBuild{Instructions: "RUN true\n", Context: dir}
+	hash := func(build *ImageBuild, baseID string) string {
+		hash, err := BuildHash(build, "dexec/lang-cpp:1.0.2", baseID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	original := hash(build, "sha256:abc")
+	if original != hash(build, "sha256:abc") {
+		t.Errorf("BuildHash() differs for the same inputs")
+	}
+	if original == hash(&ImageBuild{Instructions: "RUN false\n", Context: dir}, "sha256:abc") {
+		t.Errorf("BuildHash() unchanged when the instructions change")
+	}
+	if original == hash(build, "sha256:def") {
+		t.Errorf("BuildHash() unchanged when the base image changes")
+	}
+	writeBuildFiles(t, dir, map[string]string{"debug.log": "two"})
+	if original != hash(build, "sha256:abc") {
+		t.Errorf("BuildHash() changed when an ignored file changed")
+	}
+	writeBuildFiles(t, dir, map[string]string{"lib/libfoo.so": "bar"})
+	if original == hash(build, "sha256:abc") {
+		t.Errorf("BuildHash() unchanged when a context file changes")
+	}
+}
+
+func TestBuildImage(t *testing.T) {
+	for _, exists := range []bool{true, false} {
+		var builds []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/build"):
+				builds = append(builds, r.URL.Query().Get("t"))
+				w.Write([]byte(`{"stream":"Successfully built 0123456789ab\n"}` + "\r\n"))
+			case strings.Contains(r.URL.Path, "/images/dexec/lang-cpp-build:"):
+				if !exists {
+					http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(`{"Id":"sha256:built"}`))
+			case strings.HasSuffix(r.URL.Path, "/json"):
+				w.Write([]byte(`{"Id":"sha256:base"}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		client, err := docker.NewClient(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		base := &ContainerImage{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2"}
+		build := &ImageBuild{Instructions: "RUN apt-get install -y libboost-dev\n"}
+		got, err := BuildImage(base, build, PullOptions{}, client)
+		server.Close()
+		if err != nil {
+			t.Fatalf("BuildImage() unexpected error %v", err)
+		}
+
+		if got.Image != "dexec/lang-cpp-build" || len(got.Version) != buildTagLength || got.Extension != "cpp" {
+			t.Errorf("BuildImage() %+v", got)
+		}
+		if exists && len(builds) > 0 {
+			t.Errorf("BuildImage() rebuilt an existing image")
+		}
+		if !exists && !reflect.DeepEqual(builds, []string{got.Reference()}) {
+			t.Errorf("BuildImage() built %q, expected %s", builds, got.Reference())
+		}
+	}
+}
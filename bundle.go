@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// bundleManifestVersion is the version of the bundle manifest format written
+// by the bundle save command. Bundles with a different version are rejected.
+const bundleManifestVersion = 1
+
+const (
+	bundleManifestName = "manifest.json"
+	bundleImagesName   = "images.tar"
+)
+
+// BundleManifest describes the images in a bundle. The images themselves are
+// held in a Docker image archive alongside the manifest whose size and SHA-256
+// checksum are recorded so the bundle can be checked before it is loaded.
+type BundleManifest struct {
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Size    int64         `json:"size"`
+	SHA256  string        `json:"sha256"`
+	Images  []BundleImage `json:"images"`
+}
+
+// BundleImage records a language image in a bundle: the name and tag it is
+// looked up by, the reference it was saved under, which may be a mirror, its
+// image ID and the registry digests it was pulled with, if any. Pinned is set
+// if the language is pinned to a digest, which dexec no longer saves in
+// bundles but may be set in those saved by older versions.
+type BundleImage struct {
+	Name      string   `json:"name"`
+	Image     string   `json:"image"`
+	Version   string   `json:"version"`
+	Reference string   `json:"reference"`
+	ID        string   `json:"id"`
+	Digests   []string `json:"digests,omitempty"`
+	Pinned    bool     `json:"pinned,omitempty"`
+}
+
+// Tag returns the name:version reference the image is tagged with when the
+// bundle is loaded.
+func (image BundleImage) Tag() string {
+	return fmt.Sprintf(dexecImageTemplate, image.Image, image.Version)
+}
+
+// PinnedBundleError is returned when a language pinned to a digest is saved
+// to or loaded from a bundle. Docker drops the registry digests of images it
+// loads and nothing in a bundle proves that an image has the digest it is
+// pinned to, so such images are always pulled from the registry instead.
+type PinnedBundleError struct {
+	Name string
+}
+
+func (e *PinnedBundleError) Error() string {
+	return fmt.Sprintf("%s is pinned to a digest, which can't be verified for an image in a bundle; "+
+		"pull it from the registry instead", e.Name)
+}
+
+// SaveBundle fetches each of the images, pulling any that are missing, and
+// writes them to filename as a tar archive holding the manifest followed by
+// a Docker image archive. The image archive is staged in a temporary file so
+// that its checksum can be recorded in the manifest.
+func SaveBundle(filename string, images []*ContainerImage, pullOptions PullOptions, now time.Time, client *docker.Client) (*BundleManifest, error) {
+	manifest := &BundleManifest{Version: bundleManifestVersion, Created: now.UTC()}
+	var references []string
+	for _, image := range images {
+		if image.Digest != "" {
+			return nil, &PinnedBundleError{image.Name}
+		}
+		local, err := FetchImage(image, pullOptions, false, client)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", image.Name, err)
+		}
+		inspected, err := client.InspectImage(local.Reference())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", image.Name, err)
+		}
+		manifest.Images = append(manifest.Images, BundleImage{
+			Name:      image.Name,
+			Image:     image.Image,
+			Version:   image.Version,
+			Reference: local.Reference(),
+			ID:        inspected.ID,
+			Digests:   inspected.RepoDigests,
+		})
+		references = append(references, local.Reference())
+	}
+
+	staged, err := ioutil.TempFile("", "dexec-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	checksum := sha256.New()
+	if err := client.ExportImages(docker.ExportImagesOptions{
+		Names:        references,
+		OutputStream: io.MultiWriter(staged, checksum),
+	}); err != nil {
+		return nil, fmt.Errorf("unable to export images: %s", err)
+	}
+	if manifest.Size, err = staged.Seek(0, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	manifest.SHA256 = hex.EncodeToString(checksum.Sum(nil))
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	writer := tar.NewWriter(file)
+	err = writeTarFile(writer, bundleManifestName, 0644, append(content, '\n'))
+	if err == nil {
+		err = writer.WriteHeader(&tar.Header{Name: bundleImagesName, Mode: 0644, Size: manifest.Size, ModTime: manifest.Created})
+	}
+	if err == nil {
+		_, err = io.Copy(writer, staged)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ReadBundleManifest reads the manifest of a bundle and checks it against
+// the image archive that follows it, returning an error if the bundle is not
+// one dexec can load or has been truncated or modified since it was saved.
+func ReadBundleManifest(filename string) (*BundleManifest, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest *BundleManifest
+	reader := tar.NewReader(file)
+	err = readBundle(reader, func(m *BundleManifest) error {
+		manifest = m
+		return nil
+	}, func(images io.Reader) error {
+		checksum := sha256.New()
+		size, err := io.Copy(checksum, images)
+		if err != nil {
+			return err
+		}
+		return manifest.check(size, checksum)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return manifest, nil
+}
+
+// readBundle reads the entries of a bundle in order, calling onManifest with
+// the decoded manifest and then onImages with the content of the image
+// archive.
+func readBundle(reader *tar.Reader, onManifest func(*BundleManifest) error, onImages func(io.Reader) error) error {
+	header, err := reader.Next()
+	if err != nil || header.Name != bundleManifestName {
+		return fmt.Errorf("not a dexec bundle, expected %s first", bundleManifestName)
+	}
+	manifest := &BundleManifest{}
+	if err := json.NewDecoder(reader).Decode(manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %s", err)
+	}
+	if manifest.Version != bundleManifestVersion {
+		return fmt.Errorf("unsupported manifest version %d, expected %d", manifest.Version, bundleManifestVersion)
+	}
+	for _, image := range manifest.Images {
+		if image.Image == "" || image.Version == "" || !strings.HasPrefix(image.ID, "sha256:") {
+			return fmt.Errorf("invalid manifest entry for %q", image.Name)
+		}
+	}
+	if err := onManifest(manifest); err != nil {
+		return err
+	}
+
+	header, err = reader.Next()
+	if err != nil || header.Name != bundleImagesName {
+		return fmt.Errorf("bundle has no %s", bundleImagesName)
+	}
+	return onImages(reader)
+}
+
+// check compares the size and checksum of an image archive with those
+// recorded in the manifest.
+func (manifest *BundleManifest) check(size int64, checksum hash.Hash) error {
+	if size != manifest.Size {
+		return fmt.Errorf("%s is %d bytes, manifest says %d", bundleImagesName, size, manifest.Size)
+	}
+	if sum := hex.EncodeToString(checksum.Sum(nil)); sum != manifest.SHA256 {
+		return fmt.Errorf("%s checksum %s does not match manifest %s", bundleImagesName, sum, manifest.SHA256)
+	}
+	return nil
+}
+
+// LoadBundle checks a bundle against its manifest and loads its images into
+// the local repository. Each image is then checked against the ID recorded in
+// the manifest and tagged with the name and version dexec looks it up by, so
+// that it is found without pulling even if it was saved from a mirror.
+func LoadBundle(filename string, client *docker.Client) (*BundleManifest, error) {
+	manifest, err := ReadBundleManifest(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range manifest.Images {
+		if image.Pinned {
+			return nil, &PinnedBundleError{image.Name}
+		}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	err = readBundle(tar.NewReader(file), func(*BundleManifest) error {
+		return nil
+	}, func(images io.Reader) error {
+		return client.LoadImage(docker.LoadImageOptions{InputStream: images, OutputStream: ioutil.Discard})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %s: %s", filename, err)
+	}
+
+	for _, image := range manifest.Images {
+		inspected, err := client.InspectImage(image.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%s: image %s was not loaded: %s", image.Name, image.ID, err)
+		}
+		if inspected.ID != image.ID {
+			return nil, fmt.Errorf("%s: loaded image %s does not match manifest %s", image.Name, inspected.ID, image.ID)
+		}
+		if err := client.TagImage(image.ID, docker.TagImageOptions{Repo: image.Image, Tag: image.Version, Force: true}); err != nil {
+			return nil, fmt.Errorf("%s: unable to tag %s: %s", image.Name, image.Tag(), err)
+		}
+	}
+	return manifest, nil
+}
+
+// DisplayBundleManifest writes the images in a bundle either as a table or
+// as JSON.
+func DisplayBundleManifest(w io.Writer, manifest *BundleManifest, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(manifest)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tIMAGE\tID")
+	for _, image := range manifest.Images {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", image.Name, image.Tag(), ShortDigest(image.ID))
+	}
+	fmt.Fprintf(table, "\n%d images, %s\n", len(manifest.Images), units.HumanSize(float64(manifest.Size)))
+	return table.Flush()
+}
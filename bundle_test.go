@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const testImageID = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// bundleServer stubs the Docker API calls made when saving and loading a
+// bundle, recording the images exported, the archive loaded and the tags
+// created.
+type bundleServer struct {
+	exported []string
+	loaded   []byte
+	tags     []string
+}
+
+func (s *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/images/get"):
+		s.exported = r.URL.Query()["names"]
+		w.Write([]byte("image archive"))
+	case strings.HasSuffix(r.URL.Path, "/images/load"):
+		s.loaded, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"stream":"Loaded image"}`))
+	case strings.HasSuffix(r.URL.Path, "/tag"):
+		s.tags = append(s.tags, r.URL.Query().Get("repo")+":"+r.URL.Query().Get("tag"))
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasSuffix(r.URL.Path, "/json"):
+		w.Write([]byte(`{"Id":"` + testImageID + `","RepoDigests":["registry.corp.local/dexec/lang-c@` + testDigest + `"]}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestSaveAndLoadBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "bundle.tar")
+
+	stub := &bundleServer{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images := []*ContainerImage{{Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2"}}
+	pullOptions := PullOptions{Mirrors: []RegistryMirror{{"dexec/", "registry.corp.local/dexec/"}}}
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	saved, err := SaveBundle(filename, images, pullOptions, now, client)
+	if err != nil {
+		t.Fatalf("SaveBundle() unexpected error %v", err)
+	}
+
+	wantImages := []BundleImage{{
+		Name:      "C",
+		Image:     "dexec/lang-c",
+		Version:   "1.0.2",
+		Reference: "registry.corp.local/dexec/lang-c:1.0.2",
+		ID:        testImageID,
+		Digests:   []string{"registry.corp.local/dexec/lang-c@" + testDigest},
+	}}
+	if !reflect.DeepEqual(saved.Images, wantImages) || saved.Size != int64(len("image archive")) {
+		t.Errorf("SaveBundle() manifest %+v", saved)
+	}
+	if !reflect.DeepEqual(stub.exported, []string{"registry.corp.local/dexec/lang-c:1.0.2"}) {
+		t.Errorf("SaveBundle() exported %q", stub.exported)
+	}
+
+	loaded, err := LoadBundle(filename, client)
+	if err != nil {
+		t.Fatalf("LoadBundle() unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Errorf("LoadBundle() manifest %+v != %+v", loaded, saved)
+	}
+	if string(stub.loaded) != "image archive" {
+		t.Errorf("LoadBundle() loaded %q", stub.loaded)
+	}
+	if !reflect.DeepEqual(stub.tags, []string{"dexec/lang-c:1.0.2"}) {
+		t.Errorf("LoadBundle() tagged %q", stub.tags)
+	}
+}
+
+func TestBundlePinnedImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stub := &bundleServer{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "C is pinned to a digest, which can't be verified for an image in a bundle; pull it from the registry instead"
+	pinned := []*ContainerImage{{Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2", Digest: testDigest}}
+	filename := filepath.Join(dir, "saved.tar")
+	if _, err := SaveBundle(filename, pinned, PullOptions{}, time.Now(), client); err == nil || err.Error() != want {
+		t.Errorf("SaveBundle() %v != %q", err, want)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) || stub.exported != nil {
+		t.Errorf("SaveBundle() saved a pinned image")
+	}
+
+	checksum := sha256.Sum256([]byte("image archive"))
+	manifest, _ := json.Marshal(BundleManifest{
+		Version: bundleManifestVersion,
+		Size:    int64(len("image archive")),
+		SHA256:  hex.EncodeToString(checksum[:]),
+		Images:  []BundleImage{{Name: "C", Image: "dexec/lang-c", Version: "1.0.2", ID: testImageID, Pinned: true}},
+	})
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	writeTarFile(writer, bundleManifestName, 0644, manifest)
+	writeTarFile(writer, bundleImagesName, 0644, []byte("image archive"))
+	writer.Close()
+	filename = filepath.Join(dir, "crafted.tar")
+	if err := ioutil.WriteFile(filename, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadBundle(filename, client); err == nil || err.Error() != want {
+		t.Errorf("LoadBundle() %v != %q", err, want)
+	}
+	if stub.loaded != nil || stub.tags != nil {
+		t.Errorf("LoadBundle() loaded %q and tagged %q from a bundle with a pinned image", stub.loaded, stub.tags)
+	}
+}
+
+func TestReadBundleManifestErrors(t *testing.T) {
+	manifest := BundleManifest{
+		Version: bundleManifestVersion,
+		Size:    int64(len("image archive")),
+		SHA256:  "0a3d51d5f0a6f15e5d3a68b1b0c47b9e66d70b1bd6c9f4e6a5f9d3b2c1e0f9a8",
+		Images:  []BundleImage{{Name: "C", Image: "dexec/lang-c", Version: "1.0.2", ID: testImageID}},
+	}
+	unsupported := manifest
+	unsupported.Version = 2
+	truncated := manifest
+	truncated.Size = 100
+
+	cases := []struct {
+		entries map[string]interface{}
+		names   []string
+		want    string
+	}{
+		{map[string]interface{}{"images.tar": "image archive"}, []string{"images.tar"}, "not a dexec bundle, expected manifest.json first"},
+		{map[string]interface{}{"manifest.json": unsupported}, []string{"manifest.json"}, "unsupported manifest version 2, expected 1"},
+		{map[string]interface{}{"manifest.json": manifest}, []string{"manifest.json"}, "bundle has no images.tar"},
+		{map[string]interface{}{"manifest.json": truncated, "images.tar": "image archive"}, []string{"manifest.json", "images.tar"}, "images.tar is 13 bytes, manifest says 100"},
+		{map[string]interface{}{"manifest.json": manifest, "images.tar": "image archive"}, []string{"manifest.json", "images.tar"}, "images.tar checksum"},
+	}
+	for _, c := range cases {
+		var archive bytes.Buffer
+		writer := tar.NewWriter(&archive)
+		for _, name := range c.names {
+			content, ok := c.entries[name].(string)
+			if !ok {
+				encoded, _ := json.Marshal(c.entries[name])
+				content = string(encoded)
+			}
+			if err := writeTarFile(writer, name, 0644, []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writer.Close()
+
+		file, err := ioutil.TempFile("", "dexec-bundle-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(archive.Bytes())
+		file.Close()
+
+		_, err = ReadBundleManifest(file.Name())
+		os.Remove(file.Name())
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("ReadBundleManifest(%q) %v does not contain %q", c.names, err, c.want)
+		}
+	}
+}
+
+func TestDisplayBundleManifest(t *testing.T) {
+	manifest := &BundleManifest{
+		Version: bundleManifestVersion,
+		Size:    2000,
+		Images:  []BundleImage{{Name: "C", Image: "dexec/lang-c", Version: "1.0.2", ID: testImageID}},
+	}
+	var out bytes.Buffer
+	if err := DisplayBundleManifest(&out, manifest, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "NAME  IMAGE               ID\nC     dexec/lang-c:1.0.2  sha256:0123456789ab\n\n1 images, 2kB\n"
+	if out.String() != want {
+		t.Errorf("DisplayBundleManifest() %q != %q", out.String(), want)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var agePattern = regexp.MustCompile(`^([0-9]+)([dw])$`)
+
+// CleanFilter narrows down the dexec images removed by the clean command.
+// Repositories, if set, limits removal to images from those repositories,
+// images created less than OlderThan before Now are kept, and Current lists
+// references to images that are kept because the registry uses them.
+type CleanFilter struct {
+	Repositories map[string]bool
+	OlderThan    time.Duration
+	Now          time.Time
+	Current      map[string]bool
+}
+
+// CleanCandidate is a local image selected for removal along with the tags,
+// or digests for an untagged image, that refer to it. If Complete is set the
+// image has no other tags, so removing them all deletes the image.
+type CleanCandidate struct {
+	ID       string
+	Names    []string
+	Size     int64
+	Created  time.Time
+	Complete bool
+}
+
+// CleanFailure records a tag or digest that could not be removed.
+type CleanFailure struct {
+	Name  string
+	Error error
+}
+
+// CleanReport records the outcome of removing the selected images.
+// Reclaimed is the total size of the images deleted, which counts layers
+// shared between them, or with images that are kept, once for each image.
+// It is therefore an upper bound on the space freed; the shared size of
+// each image isn't known from the image list, so it can't be taken off.
+type CleanReport struct {
+	Removed   []string
+	Failures  []CleanFailure
+	Reclaimed int64
+	Images    int
+}
+
+// ParseAge takes an age such as 30d, 2w or 12h and returns it as a
+// duration. Days and weeks are supported as well as the units understood by
+// time.ParseDuration.
+func ParseAge(age string) (time.Duration, error) {
+	if match := agePattern.FindStringSubmatch(age); match != nil {
+		count, _ := strconv.Atoi(match[1])
+		day := 24 * time.Hour
+		if match[2] == "w" {
+			return time.Duration(count) * 7 * day, nil
+		}
+		return time.Duration(count) * day, nil
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid age %q, expected e.g. 30d, 2w or 12h", age)
+	}
+	return duration, nil
+}
+
+// LanguageRepositories returns the repositories, including mirrored ones and
+// those of customised images, that hold images for the given language.
+func LanguageRepositories(language string, mirrors []RegistryMirror) (map[string]bool, error) {
+	repositories := map[string]bool{}
+	for _, extension := range registryExtensions() {
+		for _, image := range registry[extension] {
+			if !MatchesLanguage(image, language) {
+				continue
+			}
+			for _, mirrored := range MirroredImages(image, mirrors) {
+				repositories[mirrored.Image] = true
+				repositories[BuildRepository(mirrored.Image)] = true
+			}
+		}
+	}
+	if len(repositories) == 0 {
+		return nil, fmt.Errorf("map does not contain language %s", language)
+	}
+	return repositories, nil
+}
+
+// CurrentReferences returns the references of the images currently used by
+// the registry, including mirrored ones.
+func CurrentReferences(mirrors []RegistryMirror) map[string]bool {
+	current := map[string]bool{}
+	for _, extension := range registryExtensions() {
+		for _, image := range registry[extension] {
+			for _, mirrored := range MirroredImages(image, mirrors) {
+				current[mirrored.Reference()] = true
+			}
+		}
+	}
+	return current
+}
+
+// SelectCleanImages returns the local dexec images that match the filter.
+// Only tags that match pattern are considered and an image whose tags or
+// digests include a current reference is always kept.
+func SelectCleanImages(images []docker.APIImages, pattern *regexp.Regexp, filter CleanFilter) []CleanCandidate {
+	var candidates []CleanCandidate
+	for _, image := range images {
+		created := time.Unix(image.Created, 0)
+		if filter.OlderThan > 0 && filter.Now.Sub(created) < filter.OlderThan {
+			continue
+		}
+
+		var tags []string
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" {
+				tags = append(tags, tag)
+			}
+		}
+		names := tags
+		if len(names) == 0 {
+			names = image.RepoDigests
+		}
+
+		var selected []string
+		current := false
+		for _, name := range JoinStringSlices(names, image.RepoDigests) {
+			if filter.Current[name] {
+				current = true
+			}
+		}
+		for _, name := range names {
+			if pattern.MatchString(name) && (filter.Repositories == nil || filter.Repositories[repositoryName(name)]) {
+				selected = append(selected, name)
+			}
+		}
+		if current || len(selected) == 0 {
+			continue
+		}
+
+		candidates = append(candidates, CleanCandidate{
+			ID:       image.ID,
+			Names:    selected,
+			Size:     image.Size,
+			Created:  created,
+			Complete: len(selected) == len(names),
+		})
+	}
+	return candidates
+}
+
+// repositoryName strips the tag or digest from an image reference.
+func repositoryName(reference string) string {
+	if i := strings.Index(reference, "@"); i >= 0 {
+		return reference[:i]
+	}
+	if i := strings.LastIndex(reference, ":"); i > strings.LastIndex(reference, "/") {
+		return reference[:i]
+	}
+	return reference
+}
+
+// RemoveCleanImages removes every tag or digest of the candidates, carrying
+// on when one can't be removed, and reports what was removed and how much
+// space was reclaimed. Nothing is removed if dryRun is set, in which case the
+// report says what would have been. Images in use by a container are only
+// removed if force is set.
+func RemoveCleanImages(candidates []CleanCandidate, dryRun bool, force bool, client *docker.Client) CleanReport {
+	var report CleanReport
+	for _, candidate := range candidates {
+		removedAll := true
+		for _, name := range candidate.Names {
+			if !dryRun {
+				if err := client.RemoveImageExtended(name, docker.RemoveImageOptions{Force: force}); err != nil {
+					report.Failures = append(report.Failures, CleanFailure{name, err})
+					removedAll = false
+					continue
+				}
+			}
+			report.Removed = append(report.Removed, name)
+		}
+		if removedAll && candidate.Complete {
+			report.Reclaimed += candidate.Size
+			report.Images++
+		}
+	}
+	return report
+}
+
+// DisplayCleanReport writes each tag or digest removed to out and each
+// failure to errOut, followed by a summary of the most space that was
+// reclaimed.
+func DisplayCleanReport(out io.Writer, errOut io.Writer, report CleanReport, dryRun bool) {
+	verb, summary := "removed", "reclaimed up to"
+	if dryRun {
+		verb, summary = "would remove", "would reclaim up to"
+	}
+	for _, name := range report.Removed {
+		fmt.Fprintf(out, "%s %s\n", verb, name)
+	}
+	for _, failure := range report.Failures {
+		fmt.Fprintf(errOut, "unable to remove %s: %s\n", failure.Name, failure.Error)
+	}
+	fmt.Fprintf(out, "%s %s from %d images", summary, units.HumanSize(float64(report.Reclaimed)), report.Images)
+	if len(report.Failures) > 0 {
+		fmt.Fprintf(out, ", %d failed", len(report.Failures))
+	}
+	fmt.Fprintln(out)
+}
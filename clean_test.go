@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestParseAge(t *testing.T) {
+	cases := []struct {
+		age       string
+		wantAge   time.Duration
+		wantError bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"30", 0, true},
+		{"-1h", 0, true},
+		{"d", 0, true},
+	}
+	for _, c := range cases {
+		gotAge, err := ParseAge(c.age)
+		if (err != nil) != c.wantError {
+			t.Errorf("ParseAge(%q) unexpected error %v", c.age, err)
+		} else if gotAge != c.wantAge {
+			t.Errorf("ParseAge(%q) %s != %s", c.age, gotAge, c.wantAge)
+		}
+	}
+}
+
+func TestRepositoryName(t *testing.T) {
+	cases := []struct {
+		reference string
+		want      string
+	}{
+		{"dexec/lang-c:1.0.2", "dexec/lang-c"},
+		{"dexec/lang-c@" + testDigest, "dexec/lang-c"},
+		{"registry.corp.local:5000/dexec/lang-c:1.0.2", "registry.corp.local:5000/dexec/lang-c"},
+		{"registry.corp.local:5000/dexec/lang-c", "registry.corp.local:5000/dexec/lang-c"},
+	}
+	for _, c := range cases {
+		if got := repositoryName(c.reference); got != c.want {
+			t.Errorf("repositoryName(%q) %q != %q", c.reference, got, c.want)
+		}
+	}
+}
+
+func TestSelectCleanImages(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := int64(24 * 60 * 60)
+	images := []docker.APIImages{
+		{ID: "c-old", RepoTags: []string{"dexec/lang-c:1.0.1"}, Created: now.Unix() - 60*day, Size: 100},
+		{ID: "c-new", RepoTags: []string{"dexec/lang-c:1.0.2"}, Created: now.Unix() - day, Size: 200},
+		{ID: "python", RepoTags: []string{"registry.corp.local/dexec/lang-python3:1.0.2", "mine:latest"}, Created: now.Unix() - 60*day, Size: 300},
+		{ID: "pinned", RepoTags: []string{"<none>:<none>"}, RepoDigests: []string{"dexec/lang-go@" + testDigest}, Created: now.Unix() - 60*day, Size: 400},
+		{ID: "other", RepoTags: []string{"ubuntu:18.04"}, Created: now.Unix() - 60*day, Size: 500},
+	}
+	pattern := CleanImagePattern([]RegistryMirror{{"dexec/", "registry.corp.local/dexec/"}})
+
+	cases := []struct {
+		filter  CleanFilter
+		wantIDs []string
+	}{
+		{CleanFilter{Now: now}, []string{"c-old", "c-new", "python", "pinned"}},
+		{CleanFilter{Now: now, OlderThan: 30 * 24 * time.Hour}, []string{"c-old", "python", "pinned"}},
+		{CleanFilter{Now: now, Repositories: map[string]bool{"dexec/lang-c": true}}, []string{"c-old", "c-new"}},
+		{CleanFilter{Now: now, Current: map[string]bool{"dexec/lang-c:1.0.2": true, "dexec/lang-go@" + testDigest: true}}, []string{"c-old", "python"}},
+	}
+	for _, c := range cases {
+		var gotIDs []string
+		for _, candidate := range SelectCleanImages(images, pattern, c.filter) {
+			gotIDs = append(gotIDs, candidate.ID)
+			if candidate.ID == "python" && (candidate.Complete || !reflect.DeepEqual(candidate.Names, []string{"registry.corp.local/dexec/lang-python3:1.0.2"})) {
+				t.Errorf("SelectCleanImages() python candidate %+v", candidate)
+			}
+			if candidate.ID == "pinned" && !reflect.DeepEqual(candidate.Names, []string{"dexec/lang-go@" + testDigest}) {
+				t.Errorf("SelectCleanImages() pinned candidate %+v", candidate)
+			}
+		}
+		if !reflect.DeepEqual(gotIDs, c.wantIDs) {
+			t.Errorf("SelectCleanImages(%+v) %q != %q", c.filter, gotIDs, c.wantIDs)
+		}
+	}
+}
+
+func TestRemoveCleanImages(t *testing.T) {
+	var removed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.Index(r.URL.Path, "/images/")+len("/images/"):]
+		if name == "dexec/lang-c:1.0.2" && r.URL.Query().Get("force") != "1" {
+			http.Error(w, `{"message":"image is being used by running container"}`, http.StatusConflict)
+			return
+		}
+		removed = append(removed, name)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := []CleanCandidate{
+		{ID: "c-old", Names: []string{"dexec/lang-c:1.0.1"}, Size: 100, Complete: true},
+		{ID: "c-new", Names: []string{"dexec/lang-c:1.0.2"}, Size: 200, Complete: true},
+		{ID: "python", Names: []string{"dexec/lang-python3:1.0.2"}, Size: 300},
+	}
+
+	report := RemoveCleanImages(candidates, true, false, client)
+	if len(removed) > 0 || len(report.Removed) != 3 || report.Reclaimed != 300 || report.Images != 2 {
+		t.Errorf("RemoveCleanImages() dry run removed %q, report %+v", removed, report)
+	}
+
+	report = RemoveCleanImages(candidates, false, false, client)
+	if !reflect.DeepEqual(removed, []string{"dexec/lang-c:1.0.1", "dexec/lang-python3:1.0.2"}) {
+		t.Errorf("RemoveCleanImages() removed %q", removed)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Name != "dexec/lang-c:1.0.2" || report.Reclaimed != 100 || report.Images != 1 {
+		t.Errorf("RemoveCleanImages() report %+v", report)
+	}
+
+	removed = nil
+	report = RemoveCleanImages(candidates, false, true, client)
+	if len(removed) != 3 || len(report.Failures) != 0 || report.Reclaimed != 300 {
+		t.Errorf("RemoveCleanImages() forced removed %q, report %+v", removed, report)
+	}
+}
+
+func TestDisplayCleanReport(t *testing.T) {
+	report := CleanReport{
+		Removed:   []string{"dexec/lang-c:1.0.1"},
+		Failures:  []CleanFailure{{"dexec/lang-c:1.0.2", &docker.Error{Status: 409, Message: "in use"}}},
+		Reclaimed: 1000,
+		Images:    1,
+	}
+	var out, errOut bytes.Buffer
+	DisplayCleanReport(&out, &errOut, report, false)
+	if want := "removed dexec/lang-c:1.0.1\nreclaimed up to 1kB from 1 images, 1 failed\n"; out.String() != want {
+		t.Errorf("DisplayCleanReport() %q != %q", out.String(), want)
+	}
+	if want := "unable to remove dexec/lang-c:1.0.2: API error (409): in use\n"; errOut.String() != want {
+		t.Errorf("DisplayCleanReport() errors %q != %q", errOut.String(), want)
+	}
+
+	out.Reset()
+	DisplayCleanReport(&out, &errOut, CleanReport{Removed: []string{"dexec/lang-c:1.0.1"}, Reclaimed: 1000, Images: 1}, true)
+	if want := "would remove dexec/lang-c:1.0.1\nwould reclaim up to 1kB from 1 images\n"; out.String() != want {
+		t.Errorf("DisplayCleanReport() dry run %q != %q", out.String(), want)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"sort"
 )
 
 // OptionType allows for the enumeration of different CLI option types.
@@ -55,13 +56,278 @@ const (
 
 	// Timeout indicates that the option specifies the timeout flag.
 	Timeout OptionType = iota
+
+	// Language indicates that the option specifies the language to use when
+	// an extension is shared by several languages or the source has none.
+	Language OptionType = iota
+
+	// ExplainFlag indicates that the option specifies that the reasons for
+	// choosing the image should be displayed.
+	ExplainFlag OptionType = iota
+
+	// ListFlag indicates that the option specifies that the supported
+	// languages should be listed.
+	ListFlag OptionType = iota
+
+	// JSONFlag indicates that the option specifies that command output should
+	// be in JSON format.
+	JSONFlag OptionType = iota
+
+	// Mirror indicates that the option specifies a registry mirror or image
+	// prefix to try before pulling an image under its own name.
+	Mirror OptionType = iota
+
+	// RegistryAuth indicates that the option specifies the credentials to
+	// pull images with, optionally for a single registry host.
+	RegistryAuth OptionType = iota
+
+	// QuietFlag indicates that the option specifies that the progress of
+	// image pulls should not be reported.
+	QuietFlag OptionType = iota
+
+	// AllFlag indicates that the option specifies that a command should apply
+	// to every language.
+	AllFlag OptionType = iota
+
+	// Jobs indicates that the option specifies how many images may be pulled
+	// at the same time.
+	Jobs OptionType = iota
+
+	// OfflineFlag indicates that the option specifies that images should never
+	// be pulled from a registry.
+	OfflineFlag OptionType = iota
+
+	// UpdatePolicy indicates that the option specifies how often images are
+	// checked for updates.
+	UpdatePolicy OptionType = iota
+
+	// DryRunFlag indicates that the option specifies that a command should
+	// only report what it would do.
+	DryRunFlag OptionType = iota
+
+	// OlderThan indicates that the option specifies the minimum age of the
+	// images to clean.
+	OlderThan OptionType = iota
+
+	// KeepCurrentFlag indicates that the option specifies that images used by
+	// the registry should not be cleaned.
+	KeepCurrentFlag OptionType = iota
+
+	// ForceFlag indicates that the option specifies that images should be
+	// removed even if they are in use.
+	ForceFlag OptionType = iota
+
+	// Dockerfile indicates that the option specifies a Dockerfile used to
+	// build a customised image on top of the dexec image.
+	Dockerfile OptionType = iota
+
+	// Platform indicates that the option specifies the os/arch platform to
+	// pull and run images for.
+	Platform OptionType = iota
+
+	// ToolchainFlag indicates that the option specifies that the version of
+	// an image's compiler or interpreter should be reported.
+	ToolchainFlag OptionType = iota
+
+	// Run indicates that the option specifies a command template to run the
+	// sources with in place of the dexec entrypoint.
+	Run OptionType = iota
+
+	// LanguageVersion indicates that the option specifies the toolchain
+	// version to use for a language.
+	LanguageVersion OptionType = iota
+
+	// Memory indicates that the option specifies the memory limit of the
+	// container.
+	Memory OptionType = iota
+
+	// MemorySwap indicates that the option specifies the limit on memory and
+	// swap together for the container.
+	MemorySwap OptionType = iota
+
+	// CPUs indicates that the option specifies how many CPUs the container
+	// may use.
+	CPUs OptionType = iota
+
+	// PidsLimit indicates that the option specifies the maximum number of
+	// processes in the container.
+	PidsLimit OptionType = iota
+
+	// Ulimit indicates that the option specifies a ulimit for the container.
+	Ulimit OptionType = iota
+
+	// StorageSize indicates that the option specifies the size limit of the
+	// container's writable layer.
+	StorageSize OptionType = iota
+
+	// Network indicates that the option specifies the network the container
+	// is attached to.
+	Network OptionType = iota
+
+	// DNS indicates that the option specifies a DNS server for the container.
+	DNS OptionType = iota
+
+	// AddHost indicates that the option specifies an extra host name to IP
+	// mapping for the container.
+	AddHost OptionType = iota
+
+	// CapDrop indicates that the option specifies a capability to drop from
+	// the container.
+	CapDrop OptionType = iota
+
+	// CapAdd indicates that the option specifies a capability to add to the
+	// container.
+	CapAdd OptionType = iota
+
+	// SecurityOpt indicates that the option specifies a security option such
+	// as a seccomp profile for the container.
+	SecurityOpt OptionType = iota
+
+	// ReadOnlyFlag indicates that the option specifies that the container's
+	// root filesystem should be read-only.
+	ReadOnlyFlag OptionType = iota
+
+	// Runtime indicates that the option specifies the OCI runtime to run the
+	// container with.
+	Runtime OptionType = iota
+
+	// Sandbox indicates that the option specifies a preset of options that
+	// isolate the container.
+	Sandbox OptionType = iota
+
+	// Env indicates that the option specifies an environment variable for
+	// the container, either as KEY=VALUE or as KEY to pass it through from
+	// the host.
+	Env OptionType = iota
+
+	// EnvFile indicates that the option specifies a file of environment
+	// variables for the container.
+	EnvFile OptionType = iota
+
+	// StdinFlag indicates that the option specifies that the program should
+	// be read from STDIN.
+	StdinFlag OptionType = iota
 )
 
-// CLI defines a data structure that represents the application's name and
-// a map of the various options to be used when starting the container.
+// optionNames maps the option types that can be set in a project
+// configuration file or environment variable to the long form of the option.
+var optionNames = map[OptionType]string{
+	Arg:             "arg",
+	BuildArg:        "build-arg",
+	Include:         "include",
+	Image:           "image",
+	Extension:       "extension",
+	UpdateFlag:      "update",
+	Timeout:         "timeout",
+	Language:        "lang",
+	Mirror:          "mirror",
+	RegistryAuth:    "registry-auth",
+	QuietFlag:       "quiet",
+	Jobs:            "jobs",
+	OfflineFlag:     "offline",
+	UpdatePolicy:    "update-policy",
+	Dockerfile:      "dockerfile",
+	Platform:        "platform",
+	Run:             "run",
+	LanguageVersion: "lang-version",
+	Memory:          "memory",
+	MemorySwap:      "memory-swap",
+	CPUs:            "cpus",
+	PidsLimit:       "pids-limit",
+	Ulimit:          "ulimit",
+	StorageSize:     "storage-size",
+	Network:         "network",
+	DNS:             "dns",
+	AddHost:         "add-host",
+	CapDrop:         "cap-drop",
+	CapAdd:          "cap-add",
+	SecurityOpt:     "security-opt",
+	ReadOnlyFlag:    "read-only",
+	Runtime:         "runtime",
+	Sandbox:         "sandbox",
+	Env:             "env",
+	EnvFile:         "env-file",
+}
+
+// optionFlags contains the configurable option types that take no value.
+var optionFlags = map[OptionType]bool{
+	UpdateFlag:   true,
+	QuietFlag:    true,
+	OfflineFlag:  true,
+	ReadOnlyFlag: true,
+}
+
+// optionRepeatable contains the configurable option types that may be given
+// more than once.
+var optionRepeatable = map[OptionType]bool{
+	Arg:             true,
+	BuildArg:        true,
+	Include:         true,
+	Mirror:          true,
+	RegistryAuth:    true,
+	LanguageVersion: true,
+	Ulimit:          true,
+	DNS:             true,
+	AddHost:         true,
+	CapDrop:         true,
+	CapAdd:          true,
+	SecurityOpt:     true,
+	Env:             true,
+	EnvFile:         true,
+}
+
+// optionCombined contains the repeatable option types whose values are
+// combined across configuration layers rather than replaced by the last
+// layer that sets them.
+var optionCombined = map[OptionType]bool{
+	Env:     true,
+	EnvFile: true,
+}
+
+// commands maps the commands that dexec accepts in place of source files to
+// their subcommands, if they have any.
+var commands = map[string][]string{
+	"config":    {"show"},
+	"languages": nil,
+	"pull":      nil,
+	"clean":     nil,
+	"bundle":    {"save", "load"},
+	"info":      nil,
+}
+
+// CLI defines a data structure that represents the application's name, the
+// command if one was given and a map of the various options to be used when
+// starting the container. Origins records where each option's values came
+// from once they have been merged with any configuration.
 type CLI struct {
 	Filename string
+	Command  []string
 	Options  map[OptionType][]string
+	Origins  map[OptionType]string
+}
+
+// LookupOptionName returns the option type for the long form of a
+// configurable option.
+func LookupOptionName(name string) (OptionType, bool) {
+	for optionType, optionName := range optionNames {
+		if optionName == name {
+			return optionType, true
+		}
+	}
+	return None, false
+}
+
+// ConfigurableOptions returns the option types that can be set outside of
+// the command line in the order in which they are declared.
+func ConfigurableOptions() []OptionType {
+	var optionTypes []OptionType
+	for optionType := range optionNames {
+		optionTypes = append(optionTypes, optionType)
+	}
+	sort.Slice(optionTypes, func(i, j int) bool {
+		return optionTypes[i] < optionTypes[j]
+	})
+	return optionTypes
 }
 
 // ArgToOption takes two candidate strings and returns a tuple consisting of
@@ -75,17 +341,81 @@ func ArgToOption(opt string, next string) (OptionType, string, int, error) {
 	patternStandaloneE := regexp.MustCompile(`^-(e|-extension)$`)
 	patternStandaloneT := regexp.MustCompile(`^-(t|-timeout)$`)
 	patternStandaloneC := regexp.MustCompile(`^-C$`)
+	patternStandaloneL := regexp.MustCompile(`^--lang$`)
+	patternStandaloneMirror := regexp.MustCompile(`^--mirror$`)
+	patternStandaloneRegistryAuth := regexp.MustCompile(`^--registry-auth$`)
+	patternStandaloneJ := regexp.MustCompile(`^-(j|-jobs)$`)
+	patternStandaloneUpdatePolicy := regexp.MustCompile(`^--update-policy$`)
+	patternStandaloneOlderThan := regexp.MustCompile(`^--older-than$`)
+	patternStandaloneDockerfile := regexp.MustCompile(`^--dockerfile$`)
+	patternStandalonePlatform := regexp.MustCompile(`^--platform$`)
+	patternStandaloneRun := regexp.MustCompile(`^--run$`)
+	patternStandaloneLanguageVersion := regexp.MustCompile(`^--lang-version$`)
+	patternStandaloneMemory := regexp.MustCompile(`^--memory$`)
+	patternStandaloneMemorySwap := regexp.MustCompile(`^--memory-swap$`)
+	patternStandaloneCPUs := regexp.MustCompile(`^--cpus$`)
+	patternStandalonePidsLimit := regexp.MustCompile(`^--pids-limit$`)
+	patternStandaloneUlimit := regexp.MustCompile(`^--ulimit$`)
+	patternStandaloneStorageSize := regexp.MustCompile(`^--storage-size$`)
+	patternStandaloneNetwork := regexp.MustCompile(`^--network$`)
+	patternStandaloneDNS := regexp.MustCompile(`^--dns$`)
+	patternStandaloneAddHost := regexp.MustCompile(`^--add-host$`)
+	patternStandaloneCapDrop := regexp.MustCompile(`^--cap-drop$`)
+	patternStandaloneCapAdd := regexp.MustCompile(`^--cap-add$`)
+	patternStandaloneSecurityOpt := regexp.MustCompile(`^--security-opt$`)
+	patternStandaloneRuntime := regexp.MustCompile(`^--runtime$`)
+	patternStandaloneSandbox := regexp.MustCompile(`^--sandbox$`)
+	patternStandaloneEnv := regexp.MustCompile(`^-(E|-env)$`)
+	patternStandaloneEnvFile := regexp.MustCompile(`^--env-file$`)
 	patternCombinationA := regexp.MustCompile(`^--arg=(.+)$`)
 	patternCombinationB := regexp.MustCompile(`^--build-arg=(.+)$`)
 	patternCombinationI := regexp.MustCompile(`^--include=(.+)$`)
 	patternCombinationM := regexp.MustCompile(`^--image=(.+)$`)
 	patternCombinationE := regexp.MustCompile(`^--extension=(.+)$`)
 	patternCombinationT := regexp.MustCompile(`^--timeout=(.+)$`)
-	patternSource := regexp.MustCompile(`^[^-_].*\..+`)
+	patternCombinationL := regexp.MustCompile(`^--lang=(.+)$`)
+	patternCombinationMirror := regexp.MustCompile(`^--mirror=(.+)$`)
+	patternCombinationRegistryAuth := regexp.MustCompile(`^--registry-auth=(.+)$`)
+	patternCombinationJ := regexp.MustCompile(`^--jobs=(.+)$`)
+	patternCombinationUpdatePolicy := regexp.MustCompile(`^--update-policy=(.+)$`)
+	patternCombinationOlderThan := regexp.MustCompile(`^--older-than=(.+)$`)
+	patternCombinationDockerfile := regexp.MustCompile(`^--dockerfile=(.+)$`)
+	patternCombinationPlatform := regexp.MustCompile(`^--platform=(.+)$`)
+	patternCombinationRun := regexp.MustCompile(`^--run=(.+)$`)
+	patternCombinationLanguageVersion := regexp.MustCompile(`^--lang-version=(.+)$`)
+	patternCombinationMemory := regexp.MustCompile(`^--memory=(.+)$`)
+	patternCombinationMemorySwap := regexp.MustCompile(`^--memory-swap=(.+)$`)
+	patternCombinationCPUs := regexp.MustCompile(`^--cpus=(.+)$`)
+	patternCombinationPidsLimit := regexp.MustCompile(`^--pids-limit=(.+)$`)
+	patternCombinationUlimit := regexp.MustCompile(`^--ulimit=(.+)$`)
+	patternCombinationStorageSize := regexp.MustCompile(`^--storage-size=(.+)$`)
+	patternCombinationNetwork := regexp.MustCompile(`^--network=(.+)$`)
+	patternCombinationDNS := regexp.MustCompile(`^--dns=(.+)$`)
+	patternCombinationAddHost := regexp.MustCompile(`^--add-host=(.+)$`)
+	patternCombinationCapDrop := regexp.MustCompile(`^--cap-drop=(.+)$`)
+	patternCombinationCapAdd := regexp.MustCompile(`^--cap-add=(.+)$`)
+	patternCombinationSecurityOpt := regexp.MustCompile(`^--security-opt=(.+)$`)
+	patternCombinationRuntime := regexp.MustCompile(`^--runtime=(.+)$`)
+	patternCombinationSandbox := regexp.MustCompile(`^--sandbox=(.+)$`)
+	patternCombinationEnv := regexp.MustCompile(`^--env=(.+)$`)
+	patternCombinationEnvFile := regexp.MustCompile(`^--env-file=(.+)$`)
+	patternSource := regexp.MustCompile(`^[^-_].*`)
 	patternUpdateFlag := regexp.MustCompile(`^-(-update|u)$`)
 	patternHelpFlag := regexp.MustCompile(`^-(-help|h)$`)
 	patternVersionFlag := regexp.MustCompile(`^-(-version|v)$`)
 	patternCleanFlag := regexp.MustCompile(`^--clean$`)
+	patternExplainFlag := regexp.MustCompile(`^--explain$`)
+	patternListFlag := regexp.MustCompile(`^--list$`)
+	patternJSONFlag := regexp.MustCompile(`^--json$`)
+	patternQuietFlag := regexp.MustCompile(`^-(-quiet|q)$`)
+	patternAllFlag := regexp.MustCompile(`^--all$`)
+	patternOfflineFlag := regexp.MustCompile(`^--offline$`)
+	patternDryRunFlag := regexp.MustCompile(`^--dry-run$`)
+	patternKeepCurrentFlag := regexp.MustCompile(`^--keep-current$`)
+	patternForceFlag := regexp.MustCompile(`^--force$`)
+	patternToolchainFlag := regexp.MustCompile(`^--toolchain$`)
+	patternReadOnlyFlag := regexp.MustCompile(`^--read-only$`)
+	patternStdinFlag := regexp.MustCompile(`^-$`)
 
 	switch {
 	case patternStandaloneA.FindStringIndex(opt) != nil:
@@ -102,6 +432,58 @@ func ArgToOption(opt string, next string) (OptionType, string, int, error) {
 		return TargetDir, next, 2, nil
 	case patternStandaloneT.FindStringIndex(opt) != nil:
 		return Timeout, next, 2, nil
+	case patternStandaloneL.FindStringIndex(opt) != nil:
+		return Language, next, 2, nil
+	case patternStandaloneMirror.FindStringIndex(opt) != nil:
+		return Mirror, next, 2, nil
+	case patternStandaloneRegistryAuth.FindStringIndex(opt) != nil:
+		return RegistryAuth, next, 2, nil
+	case patternStandaloneJ.FindStringIndex(opt) != nil:
+		return Jobs, next, 2, nil
+	case patternStandaloneUpdatePolicy.FindStringIndex(opt) != nil:
+		return UpdatePolicy, next, 2, nil
+	case patternStandaloneOlderThan.FindStringIndex(opt) != nil:
+		return OlderThan, next, 2, nil
+	case patternStandaloneDockerfile.FindStringIndex(opt) != nil:
+		return Dockerfile, next, 2, nil
+	case patternStandalonePlatform.FindStringIndex(opt) != nil:
+		return Platform, next, 2, nil
+	case patternStandaloneRun.FindStringIndex(opt) != nil:
+		return Run, next, 2, nil
+	case patternStandaloneLanguageVersion.FindStringIndex(opt) != nil:
+		return LanguageVersion, next, 2, nil
+	case patternStandaloneMemory.FindStringIndex(opt) != nil:
+		return Memory, next, 2, nil
+	case patternStandaloneMemorySwap.FindStringIndex(opt) != nil:
+		return MemorySwap, next, 2, nil
+	case patternStandaloneCPUs.FindStringIndex(opt) != nil:
+		return CPUs, next, 2, nil
+	case patternStandalonePidsLimit.FindStringIndex(opt) != nil:
+		return PidsLimit, next, 2, nil
+	case patternStandaloneUlimit.FindStringIndex(opt) != nil:
+		return Ulimit, next, 2, nil
+	case patternStandaloneStorageSize.FindStringIndex(opt) != nil:
+		return StorageSize, next, 2, nil
+	case patternStandaloneNetwork.FindStringIndex(opt) != nil:
+		return Network, next, 2, nil
+	case patternStandaloneDNS.FindStringIndex(opt) != nil:
+		return DNS, next, 2, nil
+	case patternStandaloneAddHost.FindStringIndex(opt) != nil:
+		return AddHost, next, 2, nil
+	case patternStandaloneCapDrop.FindStringIndex(opt) != nil:
+		return CapDrop, next, 2, nil
+	case patternStandaloneCapAdd.FindStringIndex(opt) != nil:
+		return CapAdd, next, 2, nil
+	case patternStandaloneSecurityOpt.FindStringIndex(opt) != nil:
+		return SecurityOpt, next, 2, nil
+	case patternStandaloneRuntime.FindStringIndex(opt) != nil:
+		return Runtime, next, 2, nil
+	case patternStandaloneSandbox.FindStringIndex(opt) != nil:
+		return Sandbox, next, 2, nil
+	case patternStandaloneEnv.FindStringIndex(opt) != nil:
+		return Env, next, 2, nil
+	case patternStandaloneEnvFile.FindStringIndex(opt) != nil:
+		return EnvFile, next, 2, nil
 	case patternCombinationA.FindStringIndex(opt) != nil:
 		return Arg, patternCombinationA.FindStringSubmatch(opt)[1], 1, nil
 	case patternCombinationB.FindStringIndex(opt) != nil:
@@ -114,6 +496,58 @@ func ArgToOption(opt string, next string) (OptionType, string, int, error) {
 		return Extension, patternCombinationE.FindStringSubmatch(opt)[1], 1, nil
 	case patternCombinationT.FindStringIndex(opt) != nil:
 		return Timeout, patternCombinationT.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationL.FindStringIndex(opt) != nil:
+		return Language, patternCombinationL.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationMirror.FindStringIndex(opt) != nil:
+		return Mirror, patternCombinationMirror.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationRegistryAuth.FindStringIndex(opt) != nil:
+		return RegistryAuth, patternCombinationRegistryAuth.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationJ.FindStringIndex(opt) != nil:
+		return Jobs, patternCombinationJ.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationUpdatePolicy.FindStringIndex(opt) != nil:
+		return UpdatePolicy, patternCombinationUpdatePolicy.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationOlderThan.FindStringIndex(opt) != nil:
+		return OlderThan, patternCombinationOlderThan.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationDockerfile.FindStringIndex(opt) != nil:
+		return Dockerfile, patternCombinationDockerfile.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationPlatform.FindStringIndex(opt) != nil:
+		return Platform, patternCombinationPlatform.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationRun.FindStringIndex(opt) != nil:
+		return Run, patternCombinationRun.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationLanguageVersion.FindStringIndex(opt) != nil:
+		return LanguageVersion, patternCombinationLanguageVersion.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationMemory.FindStringIndex(opt) != nil:
+		return Memory, patternCombinationMemory.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationMemorySwap.FindStringIndex(opt) != nil:
+		return MemorySwap, patternCombinationMemorySwap.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationCPUs.FindStringIndex(opt) != nil:
+		return CPUs, patternCombinationCPUs.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationPidsLimit.FindStringIndex(opt) != nil:
+		return PidsLimit, patternCombinationPidsLimit.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationUlimit.FindStringIndex(opt) != nil:
+		return Ulimit, patternCombinationUlimit.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationStorageSize.FindStringIndex(opt) != nil:
+		return StorageSize, patternCombinationStorageSize.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationNetwork.FindStringIndex(opt) != nil:
+		return Network, patternCombinationNetwork.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationDNS.FindStringIndex(opt) != nil:
+		return DNS, patternCombinationDNS.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationAddHost.FindStringIndex(opt) != nil:
+		return AddHost, patternCombinationAddHost.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationCapDrop.FindStringIndex(opt) != nil:
+		return CapDrop, patternCombinationCapDrop.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationCapAdd.FindStringIndex(opt) != nil:
+		return CapAdd, patternCombinationCapAdd.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationSecurityOpt.FindStringIndex(opt) != nil:
+		return SecurityOpt, patternCombinationSecurityOpt.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationRuntime.FindStringIndex(opt) != nil:
+		return Runtime, patternCombinationRuntime.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationSandbox.FindStringIndex(opt) != nil:
+		return Sandbox, patternCombinationSandbox.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationEnv.FindStringIndex(opt) != nil:
+		return Env, patternCombinationEnv.FindStringSubmatch(opt)[1], 1, nil
+	case patternCombinationEnvFile.FindStringIndex(opt) != nil:
+		return EnvFile, patternCombinationEnvFile.FindStringSubmatch(opt)[1], 1, nil
 	case patternUpdateFlag.FindStringIndex(opt) != nil:
 		return UpdateFlag, "", 1, nil
 	case patternHelpFlag.FindStringIndex(opt) != nil:
@@ -122,6 +556,30 @@ func ArgToOption(opt string, next string) (OptionType, string, int, error) {
 		return VersionFlag, "", 1, nil
 	case patternCleanFlag.FindStringIndex(opt) != nil:
 		return CleanFlag, "", 1, nil
+	case patternExplainFlag.FindStringIndex(opt) != nil:
+		return ExplainFlag, "", 1, nil
+	case patternListFlag.FindStringIndex(opt) != nil:
+		return ListFlag, "", 1, nil
+	case patternJSONFlag.FindStringIndex(opt) != nil:
+		return JSONFlag, "", 1, nil
+	case patternQuietFlag.FindStringIndex(opt) != nil:
+		return QuietFlag, "", 1, nil
+	case patternAllFlag.FindStringIndex(opt) != nil:
+		return AllFlag, "", 1, nil
+	case patternOfflineFlag.FindStringIndex(opt) != nil:
+		return OfflineFlag, "", 1, nil
+	case patternDryRunFlag.FindStringIndex(opt) != nil:
+		return DryRunFlag, "", 1, nil
+	case patternKeepCurrentFlag.FindStringIndex(opt) != nil:
+		return KeepCurrentFlag, "", 1, nil
+	case patternForceFlag.FindStringIndex(opt) != nil:
+		return ForceFlag, "", 1, nil
+	case patternToolchainFlag.FindStringIndex(opt) != nil:
+		return ToolchainFlag, "", 1, nil
+	case patternReadOnlyFlag.FindStringIndex(opt) != nil:
+		return ReadOnlyFlag, "", 1, nil
+	case patternStdinFlag.FindStringIndex(opt) != nil:
+		return StdinFlag, "", 1, nil
 	case patternSource.FindStringIndex(opt) != nil:
 		return Source, opt, 1, nil
 	default:
@@ -153,13 +611,36 @@ func ParseArgs(args []string) map[OptionType][]string {
 	return optionMap
 }
 
+// ExtractCommand takes a string slice of arguments and splits off the
+// command and subcommand at the start of it, if present. It returns the
+// command words and the remaining arguments.
+func ExtractCommand(args []string) ([]string, []string) {
+	if len(args) == 0 {
+		return nil, args
+	}
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return nil, args
+	}
+	if len(args) > 1 {
+		for _, subcommand := range subcommands {
+			if args[1] == subcommand {
+				return args[:2], args[2:]
+			}
+		}
+	}
+	return args[:1], args[1:]
+}
+
 // ParseOsArgs takes a string slice representing the full arguments passed to
 // the program, including the filename and returns a CLI containing the
-// filename and map of option types to their values.
+// filename, command and map of option types to their values.
 func ParseOsArgs(args []string) CLI {
+	command, remainder := ExtractCommand(args[1:])
 	return CLI{
 		Filename: args[0],
-		Options:  ParseArgs(args[1:]),
+		Command:  command,
+		Options:  ParseArgs(remainder),
 	}
 }
 
@@ -170,19 +651,76 @@ func DisplayHelp(filename string) {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Printf("\t%s [options] <source files...>\n", filename)
+	fmt.Printf("\t%s [options] - < <source>\n", filename)
+	fmt.Printf("\t%s config show [options]\n", filename)
+	fmt.Printf("\t%s languages [--json]\n", filename)
+	fmt.Printf("\t%s pull [options] <languages...>|--all\n", filename)
+	fmt.Printf("\t%s clean [options]\n", filename)
+	fmt.Printf("\t%s bundle save <file> [options] <languages...>|--all\n", filename)
+	fmt.Printf("\t%s bundle load <file>\n", filename)
+	fmt.Printf("\t%s info [--toolchain] [--json] <language>\n", filename)
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Printf("\t%-36s%s\n", "config show", "Show the effective options and where they were set")
+	fmt.Printf("\t%-36s%s\n", "languages, --list", "List supported languages and local image status")
+	fmt.Printf("\t%-36s%s\n", "pull", "Pull the images for languages given by name or extension")
+	fmt.Printf("\t%-36s%s\n", "clean, --clean", "Remove local dexec images")
+	fmt.Printf("\t%-36s%s\n", "bundle save", "Save the images for languages to a bundle file")
+	fmt.Printf("\t%-36s%s\n", "bundle load", "Load the images from a bundle file")
+	fmt.Printf("\t%-36s%s\n", "info", "Show the local image for a language given by name or extension")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Printf("\t%-36s%s\n", "-C <dir>", "Specify source directory")
 	fmt.Printf("\t%-36s%s\n", "--arg, -a <argument>", "Pass <argument> to the executing code")
 	fmt.Printf("\t%-36s%s\n", "--build-arg, -b <build argument>", "Pass <build argument> to compiler")
 	fmt.Printf("\t%-36s%s\n", "--include, -i <file|path>", "Mount local <file|path> in dexec container")
+	fmt.Printf("\t%-36s%s\n", "--env, -E <KEY=VALUE|KEY>", "Set an environment variable, or pass KEY from the host")
+	fmt.Printf("\t%-36s%s\n", "--env-file <file>", "Set the environment variables in <file>")
 	fmt.Printf("\t%-36s%s\n", "--extension, -e <extension>", "Override the image used by <extension>")
 	fmt.Printf("\t%-36s%s\n", "--timeout, -t <time>", "Kill the container if running over <time> in seconds")
 	fmt.Printf("\t%-36s%s\n", "--image, -m <name>", "Override the image used by <name>")
+	fmt.Printf("\t%-36s%s\n", "--lang <language>", "Choose the language for a shared extension")
+	fmt.Printf("\t%-36s%s\n", "--dockerfile <file>", "Build a customised image from <file> and run it")
+	fmt.Printf("\t%-36s%s\n", "--platform <os/arch>", "Pull and run images for <os/arch>, e.g. linux/amd64")
+	fmt.Printf("\t%-36s%s\n", "--lang-version <language=version>", "Use the official toolchain image at <version> for <language>")
+	fmt.Printf("\t%-36s%s\n", "--run <command>", "Run the sources with <command>, e.g. 'python {sources} {args}'")
+	fmt.Printf("\t%-36s%s\n", "--memory <size>", "Limit the container's memory, e.g. 512m")
+	fmt.Printf("\t%-36s%s\n", "--memory-swap <size>", "Limit memory and swap together, or -1 for unlimited swap")
+	fmt.Printf("\t%-36s%s\n", "--cpus <number>", "Limit the number of CPUs the container may use")
+	fmt.Printf("\t%-36s%s\n", "--pids-limit <number>", "Limit the number of processes in the container")
+	fmt.Printf("\t%-36s%s\n", "--ulimit <name=soft[:hard]>", "Set a ulimit in the container, e.g. nofile=1024")
+	fmt.Printf("\t%-36s%s\n", "--storage-size <size>", "Limit the size of the container's writable layer")
+	fmt.Printf("\t%-36s%s\n", "--network <none|bridge|host|name>", "Attach the container to a network, or none")
+	fmt.Printf("\t%-36s%s\n", "--dns <ip>", "Use the DNS server at <ip> in the container")
+	fmt.Printf("\t%-36s%s\n", "--add-host <host:ip>", "Add a host name to IP mapping to the container")
+	fmt.Printf("\t%-36s%s\n", "--cap-drop <capability>", "Drop a capability from the container, or ALL")
+	fmt.Printf("\t%-36s%s\n", "--cap-add <capability>", "Add a capability to the container")
+	fmt.Printf("\t%-36s%s\n", "--security-opt <option>", "Set a security option, e.g. seccomp=<file>")
+	fmt.Printf("\t%-36s%s\n", "--read-only", "Make the root filesystem read-only, with a tmpfs on /tmp")
+	fmt.Printf("\t%-36s%s\n", "--runtime <runtime>", "Run the container with another OCI runtime, e.g. runsc")
+	fmt.Printf("\t%-36s%s\n", "--sandbox <strict|none>", "Isolate the container with a preset of the options above")
+	fmt.Printf("\t%-36s%s\n", "--mirror <[source=]prefix>", "Pull dexec/ (or <source>) images from <prefix> first")
+	fmt.Printf("\t%-36s%s\n", "--registry-auth <[host=]user:pass>", "Pull images with the given credentials")
+	fmt.Printf("\t%-36s%s\n", "--explain", "Explain how the image was chosen")
+	fmt.Printf("\t%-36s%s\n", "--json", "Output command results as JSON")
 	fmt.Printf("\t%-36s%s\n", "--update, -u", "Force update of image")
-	fmt.Printf("\t%-36s%s\n", "--clean", "Remove all local dexec images")
+	fmt.Printf("\t%-36s%s\n", "--update-policy <policy>", "Check images for updates never, daily, weekly or always")
+	fmt.Printf("\t%-36s%s\n", "--offline", "Never pull images, fail if one is missing")
+	fmt.Printf("\t%-36s%s\n", "--quiet, -q", "Don't report the progress of image pulls")
+	fmt.Printf("\t%-36s%s\n", "--all", "Pull or save the images for all languages")
+	fmt.Printf("\t%-36s%s\n", "--jobs, -j <number>", "Pull up to <number> images at once (default 4)")
+	fmt.Printf("\t%-36s%s\n", "--older-than <age>", "Clean only images older than <age>, e.g. 30d")
+	fmt.Printf("\t%-36s%s\n", "--keep-current", "Don't clean the images currently used for each language")
+	fmt.Printf("\t%-36s%s\n", "--dry-run", "Show the images that would be cleaned")
+	fmt.Printf("\t%-36s%s\n", "--force", "Clean images even if they are in use")
+	fmt.Printf("\t%-36s%s\n", "--toolchain", "Report the compiler or interpreter version with info")
 	fmt.Printf("\t%-36s%s\n", "--help, -h", "Show help")
 	fmt.Printf("\t%-36s%s\n", "--version, -v", "Display version info")
+	fmt.Println()
+	fmt.Println("Configuration:")
+	fmt.Println("\tDefaults for options with a long form, e.g. --timeout or --mirror, are read")
+	fmt.Println("\tfrom the nearest .dexecrc or dexec.yaml file and from DEXEC_ environment")
+	fmt.Println("\tvariables, e.g. DEXEC_TIMEOUT. The command line wins. See 'config show'.")
 }
 
 // DisplayVersion prints the version information for the program.
@@ -50,6 +50,10 @@ func TestGet(t *testing.T) {
 			OptionData{"foo.bar", ""},
 			WantedData{Source, "foo.bar", 1, ""},
 		},
+		{
+			OptionData{"foo", ""},
+			WantedData{Source, "foo", 1, ""},
+		},
 		{
 			OptionData{"-b", "foo"},
 			WantedData{BuildArg, "foo", 2, ""},
@@ -130,6 +134,202 @@ func TestGet(t *testing.T) {
 			OptionData{"--clean", ""},
 			WantedData{CleanFlag, "", 1, ""},
 		},
+		{
+			OptionData{"--lang", "octave"},
+			WantedData{Language, "octave", 2, ""},
+		},
+		{
+			OptionData{"--lang=octave", ""},
+			WantedData{Language, "octave", 1, ""},
+		},
+		{
+			OptionData{"--explain", ""},
+			WantedData{ExplainFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--older-than", "30d"},
+			WantedData{OlderThan, "30d", 2, ""},
+		},
+		{
+			OptionData{"--older-than=2w", ""},
+			WantedData{OlderThan, "2w", 1, ""},
+		},
+		{
+			OptionData{"--dockerfile", "Dockerfile.dexec"},
+			WantedData{Dockerfile, "Dockerfile.dexec", 2, ""},
+		},
+		{
+			OptionData{"--dockerfile=Dockerfile.dexec", ""},
+			WantedData{Dockerfile, "Dockerfile.dexec", 1, ""},
+		},
+		{
+			OptionData{"--platform", "linux/arm64"},
+			WantedData{Platform, "linux/arm64", 2, ""},
+		},
+		{
+			OptionData{"--platform=linux/amd64", ""},
+			WantedData{Platform, "linux/amd64", 1, ""},
+		},
+		{
+			OptionData{"--run", "python {sources} {args}"},
+			WantedData{Run, "python {sources} {args}", 2, ""},
+		},
+		{
+			OptionData{"--run=ruby {sources}", ""},
+			WantedData{Run, "ruby {sources}", 1, ""},
+		},
+		{
+			OptionData{"--lang-version", "python=3.12"},
+			WantedData{LanguageVersion, "python=3.12", 2, ""},
+		},
+		{
+			OptionData{"--lang-version=go=1.21", ""},
+			WantedData{LanguageVersion, "go=1.21", 1, ""},
+		},
+		{
+			OptionData{"--memory", "512m"},
+			WantedData{Memory, "512m", 2, ""},
+		},
+		{
+			OptionData{"--memory-swap=-1", ""},
+			WantedData{MemorySwap, "-1", 1, ""},
+		},
+		{
+			OptionData{"--cpus", "1.5"},
+			WantedData{CPUs, "1.5", 2, ""},
+		},
+		{
+			OptionData{"--pids-limit=64", ""},
+			WantedData{PidsLimit, "64", 1, ""},
+		},
+		{
+			OptionData{"--ulimit", "nofile=1024:2048"},
+			WantedData{Ulimit, "nofile=1024:2048", 2, ""},
+		},
+		{
+			OptionData{"--storage-size=1g", ""},
+			WantedData{StorageSize, "1g", 1, ""},
+		},
+		{
+			OptionData{"--network", "none"},
+			WantedData{Network, "none", 2, ""},
+		},
+		{
+			OptionData{"--network=ci", ""},
+			WantedData{Network, "ci", 1, ""},
+		},
+		{
+			OptionData{"--dns", "10.0.0.2"},
+			WantedData{DNS, "10.0.0.2", 2, ""},
+		},
+		{
+			OptionData{"--add-host=db:10.0.0.5", ""},
+			WantedData{AddHost, "db:10.0.0.5", 1, ""},
+		},
+		{
+			OptionData{"--cap-drop", "ALL"},
+			WantedData{CapDrop, "ALL", 2, ""},
+		},
+		{
+			OptionData{"--cap-add=NET_BIND_SERVICE", ""},
+			WantedData{CapAdd, "NET_BIND_SERVICE", 1, ""},
+		},
+		{
+			OptionData{"--security-opt", "no-new-privileges"},
+			WantedData{SecurityOpt, "no-new-privileges", 2, ""},
+		},
+		{
+			OptionData{"--read-only", ""},
+			WantedData{ReadOnlyFlag, "", 1, ""},
+		},
+		{
+			OptionData{"-", "--lang"},
+			WantedData{StdinFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--runtime=runsc", ""},
+			WantedData{Runtime, "runsc", 1, ""},
+		},
+		{
+			OptionData{"--sandbox", "strict"},
+			WantedData{Sandbox, "strict", 2, ""},
+		},
+		{
+			OptionData{"-E", "DEBUG=1"},
+			WantedData{Env, "DEBUG=1", 2, ""},
+		},
+		{
+			OptionData{"--env", "HOME"},
+			WantedData{Env, "HOME", 2, ""},
+		},
+		{
+			OptionData{"--env=DEBUG=1", ""},
+			WantedData{Env, "DEBUG=1", 1, ""},
+		},
+		{
+			OptionData{"--env-file", ".env"},
+			WantedData{EnvFile, ".env", 2, ""},
+		},
+		{
+			OptionData{"--env-file=.env", ""},
+			WantedData{EnvFile, ".env", 1, ""},
+		},
+		{
+			OptionData{"--toolchain", ""},
+			WantedData{ToolchainFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--dry-run", ""},
+			WantedData{DryRunFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--keep-current", ""},
+			WantedData{KeepCurrentFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--force", ""},
+			WantedData{ForceFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--update-policy", "daily"},
+			WantedData{UpdatePolicy, "daily", 2, ""},
+		},
+		{
+			OptionData{"--update-policy=weekly", ""},
+			WantedData{UpdatePolicy, "weekly", 1, ""},
+		},
+		{
+			OptionData{"--offline", ""},
+			WantedData{OfflineFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--all", ""},
+			WantedData{AllFlag, "", 1, ""},
+		},
+		{
+			OptionData{"-j", "8"},
+			WantedData{Jobs, "8", 2, ""},
+		},
+		{
+			OptionData{"--jobs=8", ""},
+			WantedData{Jobs, "8", 1, ""},
+		},
+		{
+			OptionData{"-q", ""},
+			WantedData{QuietFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--quiet", ""},
+			WantedData{QuietFlag, "", 1, ""},
+		},
+		{
+			OptionData{"--mirror", "registry.corp.local/dexec/"},
+			WantedData{Mirror, "registry.corp.local/dexec/", 2, ""},
+		},
+		{
+			OptionData{"--mirror=registry.corp.local/dexec/", ""},
+			WantedData{Mirror, "registry.corp.local/dexec/", 1, ""},
+		},
 	}
 	for _, c := range cases {
 		gotOptionType, gotOptionValue, gotChomped, _ := ArgToOption(c.opt.first, c.opt.second)
@@ -346,3 +546,27 @@ func TestOrdering(t *testing.T) {
 		}
 	}
 }
+
+func TestCommand(t *testing.T) {
+	cases := []struct {
+		osArgs      []string
+		wantCommand []string
+		wantSources []string
+	}{
+		{[]string{"filename", "config", "show", "-C", "foo"}, []string{"config", "show"}, nil},
+		{[]string{"filename", "config"}, []string{"config"}, nil},
+		{[]string{"filename", "pull", "python", "c", "-j", "2"}, []string{"pull"}, []string{"python", "c"}},
+		{[]string{"filename", "bundle", "save", "images.tar", "python", "--lang", "go"}, []string{"bundle", "save"}, []string{"images.tar", "python"}},
+		{[]string{"filename", "bundle", "load", "images.tar"}, []string{"bundle", "load"}, []string{"images.tar"}},
+		{[]string{"filename", "info", "cpp", "--toolchain"}, []string{"info"}, []string{"cpp"}},
+		{[]string{"filename", "foo.c"}, nil, []string{"foo.c"}},
+	}
+	for _, c := range cases {
+		got := ParseOsArgs(c.osArgs)
+		if !reflect.DeepEqual(got.Command, c.wantCommand) {
+			t.Errorf("ParseOsArgs %q != %q", got.Command, c.wantCommand)
+		} else if !reflect.DeepEqual(got.Options[Source], c.wantSources) {
+			t.Errorf("ParseOsArgs %q != %q", got.Options[Source], c.wantSources)
+		}
+	}
+}
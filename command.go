@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// commandPlaceholderPattern matches the placeholders in a command template.
+// Anything else in braces, such as ${HOME} in a shell script, is left alone.
+var commandPlaceholderPattern = regexp.MustCompile(`\{(sources|source|build-args|args)\}`)
+
+// shellSafePattern matches values that can be passed to a shell unquoted.
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_./:=@%+,-]+$`)
+
+// CommandFromOptions returns the command template given with --run, or the
+// one from the image's registry entry if there isn't one. It returns the
+// empty string if the image is run with the dexec entrypoint.
+func CommandFromOptions(options map[OptionType][]string, image *ContainerImage) string {
+	if len(options[Run]) > 0 {
+		return options[Run][0]
+	}
+	return image.Command
+}
+
+// ExpandCommand splits a command template such as 'python {sources} {args}'
+// into words and expands its placeholders. {sources} is replaced by the
+// sources, {source} by the first source, {build-args} by the build arguments
+// and {args} by the arguments. A placeholder that makes up a whole word
+// becomes one word for each value, while one inside a larger word, such as
+// the script given to 'sh -c', is replaced by the values quoted for a shell
+// and separated by spaces.
+func ExpandCommand(template string, sources []string, buildArgs []string, args []string) ([]string, error) {
+	words, err := SplitArgs(template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command %q: %s", template, err)
+	}
+
+	var first []string
+	if len(sources) > 0 {
+		first = sources[:1]
+	}
+	values := map[string][]string{
+		"sources":    sources,
+		"source":     first,
+		"build-args": buildArgs,
+		"args":       args,
+	}
+
+	var expanded []string
+	for _, word := range words {
+		if match := commandPlaceholderPattern.FindStringSubmatch(word); match != nil && match[0] == word {
+			expanded = append(expanded, values[match[1]]...)
+			continue
+		}
+		expanded = append(expanded, commandPlaceholderPattern.ReplaceAllStringFunc(word, func(placeholder string) string {
+			var quoted []string
+			for _, value := range values[strings.Trim(placeholder, "{}")] {
+				quoted = append(quoted, shellQuote(value))
+			}
+			return strings.Join(quoted, " ")
+		}))
+	}
+
+	if len(expanded) == 0 {
+		return nil, fmt.Errorf("command %q expands to nothing", template)
+	}
+	return expanded, nil
+}
+
+// shellQuote returns a value quoted for a POSIX shell, or the value itself if
+// it needs no quoting.
+func shellQuote(value string) string {
+	if shellSafePattern.MatchString(value) {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// StripShebang returns the content with its first line blanked out if it is
+// a shebang, so that interpreters that don't treat '#!' as a comment can run
+// it. The line break is kept so that line numbers in error messages still
+// match the source. It reports whether there was a shebang.
+func StripShebang(content []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return content, false
+	}
+	if end := bytes.IndexByte(content, '\n'); end >= 0 {
+		return content[end:], true
+	}
+	return nil, true
+}
+
+// StripSourceShebangs returns the Docker volume arguments for the sources of
+// a command template run. Sources that start with a shebang are copied
+// without it to a temporary directory, which is mounted in place of the
+// original, while other sources are mounted as they are. The returned
+// function removes the temporary directory.
+func StripSourceShebangs(path string, sources []string, extension string) ([]string, func(), error) {
+	var binds []string
+	var dir string
+	cleanup := func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}
+
+	for _, source := range sources {
+		basename, _ := ExtractBasenameAndPermission(source)
+		filename := filepath.Join(path, basename)
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		stripped, ok := StripShebang(content)
+		if !ok {
+			binds = append(binds, BuildSourceVolumeArg(path, source, extension))
+			continue
+		}
+
+		if dir == "" {
+			if dir, err = ioutil.TempDir("", "dexec-run-"); err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+		}
+		target := filepath.Join(dir, basename)
+		info, err := os.Stat(filename)
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(target, stripped, info.Mode().Perm())
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		binds = append(binds, BuildSourceVolumeArg(SanitisePath(dir, runtime.GOOS), source, extension))
+	}
+	return binds, cleanup, nil
+}
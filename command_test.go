@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandFromOptions(t *testing.T) {
+	image := &ContainerImage{Name: "Python", Extension: "py", Image: "python", Version: "3.12", Command: "python {sources} {args}"}
+	cases := []struct {
+		options map[OptionType][]string
+		want    string
+	}{
+		{map[OptionType][]string{}, "python {sources} {args}"},
+		{map[OptionType][]string{Run: {"python -u {sources}"}}, "python -u {sources}"},
+	}
+	for _, c := range cases {
+		if got := CommandFromOptions(c.options, image); got != c.want {
+			t.Errorf("CommandFromOptions(%v) %q != %q", c.options, got, c.want)
+		}
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	cases := []struct {
+		template  string
+		sources   []string
+		buildArgs []string
+		args      []string
+		want      []string
+		wantError string
+	}{
+		{"python {sources} {args}", []string{"a.py", "b.py"}, nil, []string{"-v", "hello world"}, []string{"python", "a.py", "b.py", "-v", "hello world"}, ""},
+		{"python {sources}", nil, nil, nil, []string{"python"}, ""},
+		{"rustc {build-args} -o /tmp/main {source}", []string{"main.rs"}, []string{"-O"}, nil, []string{"rustc", "-O", "-o", "/tmp/main", "main.rs"}, ""},
+		{"sh -c 'rustc {build-args} {sources} -o /tmp/main && /tmp/main {args}'", []string{"main.rs"}, []string{"-C", "opt-level=3"}, []string{"it's"},
+			[]string{"sh", "-c", `rustc -C opt-level=3 main.rs -o /tmp/main && /tmp/main 'it'\''s'`}, ""},
+		{"--out={source}.bin", []string{"main.zig"}, nil, nil, []string{"--out=main.zig.bin"}, ""},
+		{"sh -c 'echo ${HOME} {unknown}'", nil, nil, nil, []string{"sh", "-c", "echo ${HOME} {unknown}"}, ""},
+		{"{args}", nil, nil, nil, nil, `command "{args}" expands to nothing`},
+		{"python 'unterminated", nil, nil, nil, nil, `invalid command "python 'unterminated": unterminated quote in "python 'unterminated"`},
+	}
+	for _, c := range cases {
+		got, err := ExpandCommand(c.template, c.sources, c.buildArgs, c.args)
+		if c.wantError != "" {
+			if err == nil || err.Error() != c.wantError {
+				t.Errorf("ExpandCommand(%q) error %v != %q", c.template, err, c.wantError)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ExpandCommand(%q) %q, %v != %q", c.template, got, err, c.want)
+		}
+	}
+}
+
+func TestStripShebang(t *testing.T) {
+	cases := []struct {
+		content   string
+		want      string
+		wantStrip bool
+	}{
+		{"#!/usr/bin/env dexec\nprint('hello')\n", "\nprint('hello')\n", true},
+		{"#!/usr/bin/env python3", "", true},
+		{"print('hello')\n", "print('hello')\n", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, stripped := StripShebang([]byte(c.content))
+		if string(got) != c.want || stripped != c.wantStrip {
+			t.Errorf("StripShebang(%q) %q, %t != %q, %t", c.content, got, stripped, c.want, c.wantStrip)
+		}
+	}
+}
+
+func TestStripSourceShebangs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "script"), []byte("#!/usr/bin/env dexec\nprint('hello')\n"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "lib.py"), []byte("x = 1\n"), 0644)
+
+	binds, cleanup, err := StripSourceShebangs(dir, []string{"script:ro", "lib.py"}, "py")
+	if err != nil {
+		t.Fatalf("StripSourceShebangs() unexpected error %v", err)
+	}
+	if len(binds) != 2 {
+		t.Fatalf("StripSourceShebangs() %q", binds)
+	}
+	if want := dir + "/lib.py:/tmp/dexec/build/lib.py"; binds[1] != want {
+		t.Errorf("StripSourceShebangs() unstripped bind %q != %q", binds[1], want)
+	}
+
+	copied := strings.TrimSuffix(binds[0], ":/tmp/dexec/build/script.py:ro")
+	if copied == binds[0] || strings.HasPrefix(copied, dir) {
+		t.Fatalf("StripSourceShebangs() stripped bind %q", binds[0])
+	}
+	content, err := ioutil.ReadFile(copied)
+	if err != nil || string(content) != "\nprint('hello')\n" {
+		t.Errorf("StripSourceShebangs() copy %q, %v", content, err)
+	}
+	if info, err := os.Stat(copied); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("StripSourceShebangs() copy mode %v, %v", info, err)
+	}
+
+	cleanup()
+	if _, err := os.Stat(copied); !os.IsNotExist(err) {
+		t.Errorf("StripSourceShebangs() cleanup left %s", copied)
+	}
+
+	if _, _, err := StripSourceShebangs(dir, []string{"missing.py"}, "py"); err == nil {
+		t.Errorf("StripSourceShebangs() expected error for a missing source")
+	}
+}
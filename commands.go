@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// RunCommand runs the command given on the command line in place of source
+// files and returns the status code to exit with.
+func RunCommand(cliParser CLI) int {
+	switch strings.Join(cliParser.Command, " ") {
+	case "config show":
+		DisplayConfig(cliParser.Options, cliParser.Origins)
+		return 0
+	case "languages":
+		return RunLanguagesCommand(cliParser.Options)
+	case "pull":
+		return RunPullCommand(cliParser.Options)
+	case "clean":
+		return RunCleanCommand(cliParser, os.Stdout)
+	case "bundle save":
+		return RunBundleSaveCommand(cliParser)
+	case "bundle load":
+		return RunBundleLoadCommand(cliParser.Options)
+	case "info":
+		return RunInfoCommand(cliParser.Options)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", strings.Join(cliParser.Command, " "))
+		DisplayHelp(cliParser.Filename)
+		return 1
+	}
+}
+
+// RunLanguagesCommand lists the languages in the registry along with the
+// local status of their images. If the Docker host cannot be reached the
+// languages are still listed, with their status unknown.
+func RunLanguagesCommand(options map[OptionType][]string) int {
+	languages := RegistryLanguages()
+
+	mirrors, err := ParseMirrors(options[Mirror])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateDocker(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to check local images: %s\n", err)
+	} else {
+		client, err := docker.NewClientFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := InspectLanguages(languages, mirrors, client); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := DisplayLanguages(os.Stdout, languages, len(options[JSONFlag]) > 0); err != nil {
+		log.Fatal(err)
+	}
+	return 0
+}
+
+// RunPullCommand fetches the images for the languages given by name or
+// extension, or for every language with --all, several at a time. It prints
+// the outcome for each image and returns a non-zero status code if any of
+// them could not be fetched.
+func RunPullCommand(options map[OptionType][]string) int {
+	jobs := defaultPullJobs
+	if values := options[Jobs]; len(values) > 0 {
+		var err error
+		if jobs, err = strconv.Atoi(values[0]); err != nil || jobs < 1 {
+			fmt.Fprintf(os.Stderr, "invalid number of jobs: %s\n", values[0])
+			return 1
+		}
+	}
+
+	var images []*ContainerImage
+	var results []PullResult
+	seen := map[string]bool{}
+	if len(options[AllFlag]) > 0 {
+		for _, image := range RegistryImages() {
+			images = append(images, image)
+			seen[image.Reference()] = true
+		}
+	} else if len(options[Source]) == 0 {
+		fmt.Fprintln(os.Stderr, "no languages to pull; give language names, extensions or --all")
+		return 1
+	}
+	for _, language := range options[Source] {
+		image, err := LookupImageByLanguage(language)
+		if err != nil {
+			results = append(results, PullResult{Name: language, Status: pullStatusFailed, Error: err.Error()})
+		} else if !seen[image.Reference()] {
+			images = append(images, image)
+			seen[image.Reference()] = true
+		}
+	}
+
+	pullOptions, err := PullOptionsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if jobs > 1 && len(images) > 1 {
+		pullOptions.Terminal = false
+	}
+
+	if err := validateDocker(); err != nil {
+		log.Fatal(err)
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	results = append(results, PullImages(images, pullOptions, len(options[UpdateFlag]) > 0, jobs, client)...)
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(os.Stderr, "dexec: %s: %s\n", result.Name, result.Error)
+		}
+	}
+
+	if err := DisplayPullResults(os.Stdout, results, len(options[JSONFlag]) > 0); err != nil {
+		log.Fatal(err)
+	}
+	if PullFailed(results) {
+		return 1
+	}
+	return 0
+}
+
+// RunCleanCommand removes the local dexec images, or only those for the
+// language given with --lang on the command line, older than --older-than or
+// not currently used by the registry with --keep-current. It reports what was
+// removed to out and returns a non-zero status code if anything could not be
+// removed.
+func RunCleanCommand(cliParser CLI, out io.Writer) int {
+	options := cliParser.Options
+
+	pullOptions, err := PullOptionsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filter := CleanFilter{Now: time.Now()}
+	if values := options[OlderThan]; len(values) > 0 {
+		if filter.OlderThan, err = ParseAge(values[0]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if values := options[Language]; len(values) > 0 && cliParser.Origins[Language] == commandLineOrigin {
+		if filter.Repositories, err = LanguageRepositories(values[0], pullOptions.Mirrors); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(options[KeepCurrentFlag]) > 0 {
+		filter.Current = CurrentReferences(pullOptions.Mirrors)
+	}
+
+	if err := validateDocker(); err != nil {
+		log.Fatal(err)
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	images, err := client.ListImages(docker.ListImagesOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dryRun := len(options[DryRunFlag]) > 0
+	candidates := SelectCleanImages(images, CleanImagePattern(pullOptions.Mirrors), filter)
+	report := RemoveCleanImages(candidates, dryRun, len(options[ForceFlag]) > 0, client)
+	DisplayCleanReport(out, os.Stderr, report, dryRun)
+
+	if len(report.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// RunBundleSaveCommand writes the images for the languages given by name or
+// extension, with --lang on the command line or for every language with
+// --all, to the bundle file named by the first argument. Missing images are
+// pulled first. Languages pinned to a digest can't be saved, and are skipped
+// with --all.
+func RunBundleSaveCommand(cliParser CLI) int {
+	options := cliParser.Options
+	if len(options[Source]) == 0 {
+		fmt.Fprintln(os.Stderr, "no bundle file given; usage: bundle save <file> [languages...]")
+		return 1
+	}
+	filename, languages := options[Source][0], options[Source][1:]
+	if cliParser.Origins[Language] == commandLineOrigin {
+		languages = append(languages, options[Language]...)
+	}
+
+	var images []*ContainerImage
+	seen := map[string]bool{}
+	if len(options[AllFlag]) > 0 {
+		for _, image := range RegistryImages() {
+			if image.Digest != "" {
+				fmt.Fprintf(os.Stderr, "dexec: skipping %s: %s\n", image.Name, &PinnedBundleError{image.Name})
+				continue
+			}
+			images = append(images, image)
+		}
+	} else if len(languages) == 0 {
+		fmt.Fprintln(os.Stderr, "no languages to save; give language names, extensions, --lang or --all")
+		return 1
+	}
+	for _, image := range images {
+		seen[image.Reference()] = true
+	}
+	for _, language := range languages {
+		image, err := LookupImageByLanguage(language)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dexec: %s\n", err)
+			return 1
+		} else if !seen[image.Reference()] {
+			images = append(images, image)
+			seen[image.Reference()] = true
+		}
+	}
+
+	pullOptions, err := PullOptionsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateDocker(); err != nil {
+		log.Fatal(err)
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manifest, err := SaveBundle(filename, images, pullOptions, time.Now(), client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dexec: unable to save bundle: %s\n", err)
+		return 1
+	}
+	if err := DisplayBundleManifest(os.Stdout, manifest, len(options[JSONFlag]) > 0); err != nil {
+		log.Fatal(err)
+	}
+	return 0
+}
+
+// RunBundleLoadCommand checks the bundle file named by the first argument
+// against its manifest and loads its images into the local repository.
+func RunBundleLoadCommand(options map[OptionType][]string) int {
+	if len(options[Source]) != 1 {
+		fmt.Fprintln(os.Stderr, "expected a single bundle file; usage: bundle load <file>")
+		return 1
+	}
+
+	if err := validateDocker(); err != nil {
+		log.Fatal(err)
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manifest, err := LoadBundle(options[Source][0], client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dexec: %s\n", err)
+		return 1
+	}
+	if err := DisplayBundleManifest(os.Stdout, manifest, len(options[JSONFlag]) > 0); err != nil {
+		log.Fatal(err)
+	}
+	return 0
+}
+
+// RunInfoCommand shows the details of the local image for the language given
+// by extension or name and, with --toolchain, the version of its compiler or
+// interpreter.
+func RunInfoCommand(options map[OptionType][]string) int {
+	if len(options[Source]) != 1 {
+		fmt.Fprintln(os.Stderr, "expected a single language; usage: info [--toolchain] [--json] <language>")
+		return 1
+	}
+	image, err := LookupImageForInfo(options[Source][0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dexec: %s\n", err)
+		return 1
+	}
+
+	mirrors, err := ParseMirrors(options[Mirror])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateDocker(); err != nil {
+		log.Fatal(err)
+	}
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	info, err := InspectImageInfo(image, mirrors, client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dexec: %s\n", err)
+		return 1
+	}
+	if len(options[ToolchainFlag]) > 0 {
+		if info.Toolchain, err = ToolchainVersion(info.Image, client); err != nil {
+			fmt.Fprintf(os.Stderr, "dexec: unable to report toolchain version: %s\n", err)
+		}
+	}
+
+	if err := DisplayImageInfo(os.Stdout, info, len(options[JSONFlag]) > 0); err != nil {
+		log.Fatal(err)
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const environmentPrefix = "DEXEC_"
+
+// commandLineOrigin is the origin of options given on the command line.
+const commandLineOrigin = "command line"
+
+// projectConfigFilenames lists the names of project configuration files in
+// the order in which they are looked for in each directory.
+var projectConfigFilenames = []string{".dexecrc", "dexec.yaml"}
+
+// OptionLayer is a set of options along with a description of where they
+// came from, e.g. a project configuration file or the command line.
+type OptionLayer struct {
+	Origin  string
+	Options map[OptionType][]string
+}
+
+// FindProjectConfig looks for a project configuration file in dir and each
+// of its parent directories in turn, returning the path of the first one
+// found or the empty string if there is none.
+func FindProjectConfig(dir string) string {
+	for {
+		for _, name := range projectConfigFilenames {
+			candidate := filepath.Join(dir, name)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ParseProjectConfig takes the name and content of a project configuration
+// file and returns the options it sets. Each key is the long form of a CLI
+// option and its value is either a single value or a list of values.
+func ParseProjectConfig(filename string, content []byte) (map[OptionType][]string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	options := map[OptionType][]string{}
+	if len(document.Content) == 0 {
+		return options, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of options", filename, root.Line)
+	}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		optionType, ok := LookupOptionName(key.Value)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown option %q", filename, key.Line, key.Value)
+		}
+		values, err := decodeStringList(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid value for %s", filename, value.Line, key.Value)
+		}
+		if optionFlags[optionType] {
+			values, err = flagValues(values)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s for %s", filename, value.Line, err, key.Value)
+			}
+		}
+		if len(values) > 0 {
+			options[optionType] = values
+		}
+	}
+	return options, nil
+}
+
+// EnvironmentOptions takes a list of environment variables in the form
+// KEY=VALUE and returns a layer containing the options set by any DEXEC_
+// prefixed variables, e.g. DEXEC_TIMEOUT or DEXEC_BUILD_ARG. Options that
+// may be given several times are split on whitespace.
+func EnvironmentOptions(environ []string) (OptionLayer, error) {
+	layer := OptionLayer{
+		Origin:  "environment",
+		Options: map[OptionType][]string{},
+	}
+	var names []string
+
+	for _, variable := range environ {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], environmentPrefix) {
+			continue
+		}
+		name := strings.ToLower(strings.Replace(strings.TrimPrefix(parts[0], environmentPrefix), "_", "-", -1))
+		optionType, ok := LookupOptionName(name)
+		if !ok || parts[1] == "" {
+			continue
+		}
+
+		values := []string{parts[1]}
+		if optionRepeatable[optionType] {
+			values = strings.Fields(parts[1])
+		}
+		if optionFlags[optionType] {
+			var err error
+			if values, err = flagValues(values); err != nil {
+				return layer, fmt.Errorf("%s: %s", parts[0], err)
+			}
+		}
+		if len(values) > 0 {
+			layer.Options[optionType] = values
+			names = append(names, parts[0])
+		}
+	}
+
+	sort.Strings(names)
+	if len(names) > 0 {
+		layer.Origin = fmt.Sprintf("environment (%s)", strings.Join(names, ", "))
+	}
+	return layer, nil
+}
+
+// flagValues converts a boolean config value to the representation used by
+// ParseArgs for a flag, i.e. a single empty string if set and nothing if not.
+func flagValues(values []string) ([]string, error) {
+	if len(values) != 1 {
+		return nil, fmt.Errorf("expected true or false")
+	}
+	switch strings.ToLower(values[0]) {
+	case "true", "yes", "1":
+		return []string{""}, nil
+	case "false", "no", "0":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("expected true or false")
+	}
+}
+
+// MergeOptions combines option layers in order of increasing precedence.
+// For each option type the values of the last layer that sets it are used,
+// apart from the option types whose values are combined, for which the
+// values of every layer are used in order. As well as the merged options, a
+// map of each option type to the origin of its values is returned.
+func MergeOptions(layers ...OptionLayer) (map[OptionType][]string, map[OptionType]string) {
+	options := map[OptionType][]string{}
+	origins := map[OptionType]string{}
+
+	for _, layer := range layers {
+		for optionType, values := range layer.Options {
+			if _, ok := options[optionType]; ok && optionCombined[optionType] {
+				options[optionType] = append(append([]string(nil), options[optionType]...), values...)
+				origins[optionType] = fmt.Sprintf("%s, %s", origins[optionType], layer.Origin)
+				continue
+			}
+			options[optionType] = values
+			origins[optionType] = layer.Origin
+		}
+	}
+	return options, origins
+}
+
+// LoadOptionLayers returns the layers that make up the effective options
+// for a run in order of increasing precedence: the project configuration
+// file, environment variables, directives in the given sources and finally
+// the command line options.
+func LoadOptionLayers(cliOptions map[OptionType][]string, sources []string) ([]OptionLayer, error) {
+	var layers []OptionLayer
+	dir := RetrievePath(cliOptions[TargetDir])
+
+	if filename := FindProjectConfig(dir); filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		options, err := ParseProjectConfig(filename, content)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, OptionLayer{filename, options})
+	}
+
+	environment, err := EnvironmentOptions(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	directives, err := SourceDirectiveOptions(dir, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(layers, environment, directives, OptionLayer{commandLineOrigin, cliOptions}), nil
+}
+
+// displayMasks maps the option types whose values may hold secrets to the
+// function that hides them when the configuration is displayed.
+var displayMasks = map[OptionType]func(string) string{
+	RegistryAuth: MaskRegistryAuth,
+	Env:          MaskEnv,
+}
+
+// DisplayConfig prints the value of every configurable option along with
+// where that value came from. Credentials and environment variable values
+// are masked.
+func DisplayConfig(options map[OptionType][]string, origins map[OptionType]string) {
+	for _, optionType := range ConfigurableOptions() {
+		value := "(unset)"
+		origin := "default"
+		if values, ok := options[optionType]; ok {
+			origin = origins[optionType]
+			if optionFlags[optionType] {
+				value = "true"
+			} else if mask, ok := displayMasks[optionType]; ok {
+				var masked []string
+				for _, value := range values {
+					masked = append(masked, mask(value))
+				}
+				value = strings.Join(masked, " ")
+			} else {
+				value = strings.Join(values, " ")
+			}
+		}
+		fmt.Printf("%-16s%-32s%s\n", optionNames[optionType], value, origin)
+	}
+}
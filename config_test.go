@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseProjectConfig(t *testing.T) {
+	content := []byte(`include: [data.txt, lib]
+build-arg: -std=c++17
+timeout: 10
+update: true
+`)
+	want := map[OptionType][]string{
+		Include:    {"data.txt", "lib"},
+		BuildArg:   {"-std=c++17"},
+		Timeout:    {"10"},
+		UpdateFlag: {""},
+	}
+	got, err := ParseProjectConfig(".dexecrc", content)
+	if err != nil {
+		t.Fatalf("ParseProjectConfig unexpected error %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseProjectConfig %q != %q", got, want)
+	}
+}
+
+func TestParseProjectConfigErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+	}{
+		{"- foo\n", ".dexecrc:1: expected a mapping of options"},
+		{"timeout: 10\nsource: foo.c\n", ".dexecrc:2: unknown option \"source\""},
+		{"update: sometimes\n", ".dexecrc:1: expected true or false for update"},
+	}
+	for _, c := range cases {
+		_, err := ParseProjectConfig(".dexecrc", []byte(c.content))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("ParseProjectConfig(%q) %v != %q", c.content, err, c.want)
+		}
+	}
+}
+
+func TestEnvironmentOptions(t *testing.T) {
+	environ := []string{
+		"HOME=/home/foo",
+		"DEXEC_TIMEOUT=5",
+		"DEXEC_BUILD_ARG=-O2 -Wall",
+		"DEXEC_UPDATE=false",
+		"DEXEC_UNKNOWN=foo",
+	}
+	want := OptionLayer{
+		Origin: "environment (DEXEC_BUILD_ARG, DEXEC_TIMEOUT)",
+		Options: map[OptionType][]string{
+			Timeout:  {"5"},
+			BuildArg: {"-O2", "-Wall"},
+		},
+	}
+	got, err := EnvironmentOptions(environ)
+	if err != nil {
+		t.Fatalf("EnvironmentOptions unexpected error %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EnvironmentOptions %v != %v", got, want)
+	}
+}
+
+func TestMergeOptions(t *testing.T) {
+	layers := []OptionLayer{
+		{"file", map[OptionType][]string{Timeout: {"10"}, Include: {"a"}, BuildArg: {"-O2"}, Env: {"A=1", "B=1"}}},
+		{"env", map[OptionType][]string{Timeout: {"5"}}},
+		{"cli", map[OptionType][]string{Include: {"b"}, Source: {"foo.c"}, Env: {"B=2"}}},
+	}
+	wantOptions := map[OptionType][]string{
+		Timeout:  {"5"},
+		Include:  {"b"},
+		BuildArg: {"-O2"},
+		Source:   {"foo.c"},
+		Env:      {"A=1", "B=1", "B=2"},
+	}
+	wantOrigins := map[OptionType]string{
+		Timeout:  "env",
+		Include:  "cli",
+		BuildArg: "file",
+		Source:   "cli",
+		Env:      "file, cli",
+	}
+	gotOptions, gotOrigins := MergeOptions(layers...)
+	if !reflect.DeepEqual(gotOptions, wantOptions) {
+		t.Errorf("MergeOptions %q != %q", gotOptions, wantOptions)
+	} else if !reflect.DeepEqual(gotOrigins, wantOrigins) {
+		t.Errorf("MergeOptions %q != %q", gotOrigins, wantOrigins)
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	nested := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	WriteFile(filepath.Join(root, "dexec.yaml"), []byte("timeout: 1\n"))
+	WriteFile(filepath.Join(root, "a", ".dexecrc"), []byte("timeout: 2\n"))
+
+	cases := []struct {
+		dir  string
+		want string
+	}{
+		{nested, filepath.Join(root, "a", ".dexecrc")},
+		{root, filepath.Join(root, "dexec.yaml")},
+	}
+	for _, c := range cases {
+		if got := FindProjectConfig(c.dir); got != c.want {
+			t.Errorf("FindProjectConfig(%q) %q != %q", c.dir, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DetectionRule pairs a content pattern with the names of the languages it
+// suggests. Every rule that matches adds one to each language's score.
+type DetectionRule struct {
+	Pattern   *regexp.Regexp
+	Languages []string
+}
+
+var shebangInterpreterPattern = regexp.MustCompile(`^#!\s*(\S+)(?:\s+(?:-\S+\s+)*(\S+))?`)
+var interpreterVersionPattern = regexp.MustCompile(`[0-9.]+$`)
+
+// interpreterLanguages maps the interpreters found in shebangs to the name of
+// the language they run. Version suffixes such as the 3 in python3 are
+// removed before lookup.
+var interpreterLanguages = map[string]string{
+	"bash":       "Bash",
+	"sh":         "Bash",
+	"clojure":    "Clojure",
+	"coffee":     "CoffeeScript",
+	"escript":    "Erlang",
+	"groovy":     "Groovy",
+	"lua":        "Lua",
+	"node":       "JavaScript",
+	"nodejs":     "JavaScript",
+	"ocaml":      "OCaml",
+	"octave":     "Octave",
+	"perl":       "Perl",
+	"perl6":      "Perl 6",
+	"raku":       "Perl 6",
+	"php":        "PHP",
+	"python":     "Python",
+	"racket":     "Racket",
+	"Rscript":    "R",
+	"ruby":       "Ruby",
+	"runghc":     "Haskell",
+	"runhaskell": "Haskell",
+	"sbcl":       "Lisp",
+	"scala":      "Scala",
+	"swipl":      "Prolog",
+}
+
+// detectionRules are tried against content whose language isn't given by an
+// interpreter shebang. An #include counts towards both C and C++, which are
+// then told apart by markers that only one of them has, such as std:: or
+// templates for C++.
+var detectionRules = []DetectionRule{
+	{regexp.MustCompile(`(?m)^package\s+main\b`), []string{"Go"}},
+	{regexp.MustCompile(`(?m)^func\s+main\(\)`), []string{"Go"}},
+	{regexp.MustCompile(`(?m)^\s*#include\s*[<"]`), []string{"C", "C++"}},
+	{regexp.MustCompile(`(?m)^\s*#include\s*<\w+\.h>`), []string{"C"}},
+	{regexp.MustCompile(`(?m)^\s*#include\s*<\w+>`), []string{"C++"}},
+	{regexp.MustCompile(`std::|using\s+namespace\s+std`), []string{"C++"}},
+	{regexp.MustCompile(`(?m)^\s*(class\s+\w+|namespace\s+\w+|template\s*<)`), []string{"C++"}},
+	{regexp.MustCompile(`\bfn\s+main\s*\(\)`), []string{"Rust"}},
+	{regexp.MustCompile(`\bprintln!\(`), []string{"Rust"}},
+	{regexp.MustCompile(`public\s+static\s+void\s+main\s*\(\s*String`), []string{"Java"}},
+	{regexp.MustCompile(`\bstatic\s+void\s+Main\s*\(`), []string{"C#"}},
+	{regexp.MustCompile(`(?m)^using\s+System;`), []string{"C#"}},
+	{regexp.MustCompile(`(?m)^\s*#import\s+<Foundation/`), []string{"Objective C"}},
+	{regexp.MustCompile(`(?m)^@(interface|implementation)\b`), []string{"Objective C"}},
+	{regexp.MustCompile(`(?m)^\s*function\s+(\[[^\]]*\]|\w+)\s*=`), []string{"Octave"}},
+	{regexp.MustCompile(`(?m)^\s*(disp|fprintf)\s*\(`), []string{"Octave"}},
+	{regexp.MustCompile(`(?m)^\s*end(function|if|for|while)\b`), []string{"Octave"}},
+	{regexp.MustCompile(`(?m)^:-\s*initialization\(`), []string{"Prolog"}},
+	{regexp.MustCompile(`(?m)^[a-z]\w*(\(.*\))?\s*:-`), []string{"Prolog"}},
+	{regexp.MustCompile(`<\?php`), []string{"PHP"}},
+	{regexp.MustCompile(`(?m)^def\s+\w+\(.*\)\s*:\s*$`), []string{"Python"}},
+	{regexp.MustCompile(`(?m)^if\s+__name__\s*==`), []string{"Python"}},
+	{regexp.MustCompile(`(?m)^(from\s+\w+\s+)?import\s+\w+\s*$`), []string{"Python"}},
+	{regexp.MustCompile(`\bconsole\.log\(`), []string{"JavaScript"}},
+	{regexp.MustCompile(`\brequire\(['"]\w+['"]\)`), []string{"JavaScript"}},
+	{regexp.MustCompile(`(?m)^\s*puts\s`), []string{"Ruby"}},
+	{regexp.MustCompile(`(?m)^\s*def\s+\w+[^:]*$`), []string{"Ruby"}},
+	{regexp.MustCompile(`(?m)^\s*use\s+strict;`), []string{"Perl"}},
+	{regexp.MustCompile(`(?m)^\s*my\s+[$@%]\w+`), []string{"Perl"}},
+	{regexp.MustCompile(`(?m)^-module\(`), []string{"Erlang"}},
+	{regexp.MustCompile(`(?m)^\(ns\s`), []string{"Clojure"}},
+	{regexp.MustCompile(`\(defn\s`), []string{"Clojure"}},
+	{regexp.MustCompile(`(?m)^#lang\s+racket`), []string{"Racket"}},
+	{regexp.MustCompile(`\(defun\s`), []string{"Lisp"}},
+	{regexp.MustCompile(`(?m)^import\s+std\.`), []string{"D"}},
+	{regexp.MustCompile(`(?m)^main\s*=\s*`), []string{"Haskell"}},
+	{regexp.MustCompile(`(?m)^module\s+\w+\s+where`), []string{"Haskell"}},
+	{regexp.MustCompile(`(?m)^let\s+\(\)\s*=`), []string{"OCaml"}},
+	{regexp.MustCompile(`\bPrintf\.printf\b`), []string{"OCaml"}},
+	{regexp.MustCompile(`\[<EntryPoint>\]`), []string{"F#"}},
+	{regexp.MustCompile(`\bprintfn\s`), []string{"F#"}},
+	{regexp.MustCompile(`(?m)^(let|open|module)\s`), []string{"F#"}},
+	{regexp.MustCompile(`(?m)^(object\s+\w+\s+extends\s+App|\s*def\s+main\s*\(\s*args\s*:\s*Array\[String\]\))`), []string{"Scala"}},
+	{regexp.MustCompile(`(?m)^local\s+\w+\s*=`), []string{"Lua"}},
+	{regexp.MustCompile(`(?m)^proc\s+\w+\*?\(.*\)\s*(:\s*\w+\s*)?=`), []string{"Nim"}},
+	{regexp.MustCompile(`\bconsole\.log\s+`), []string{"CoffeeScript"}},
+}
+
+// AmbiguousLanguageError is returned by DetectLanguage when more than one
+// language is an equally good match for the content.
+type AmbiguousLanguageError struct {
+	Candidates []*ContainerImage
+}
+
+func (e *AmbiguousLanguageError) Error() string {
+	var names []string
+	for _, candidate := range e.Candidates {
+		names = append(names, fmt.Sprintf("%s (.%s)", candidate.Name, candidate.Extension))
+	}
+	return fmt.Sprintf(
+		"language is ambiguous, candidates are %s; use --lang or --extension to choose one",
+		strings.Join(names, ", "))
+}
+
+// ShebangLanguage returns the name of the language run by the interpreter in
+// the first line of content, or the empty string if there is no shebang or
+// the interpreter is not recognised. Shebangs that invoke dexec itself are
+// ignored.
+func ShebangLanguage(content []byte) string {
+	line := string(content)
+	if index := strings.IndexByte(line, '\n'); index >= 0 {
+		line = line[:index]
+	}
+
+	match := shebangInterpreterPattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if match == nil {
+		return ""
+	}
+
+	interpreter := path.Base(match[1])
+	if interpreter == "env" {
+		interpreter = path.Base(match[2])
+	}
+	if language, ok := interpreterLanguages[interpreter]; ok {
+		return language
+	}
+	return interpreterLanguages[interpreterVersionPattern.ReplaceAllString(interpreter, "")]
+}
+
+// ScoreLanguages returns the number of detection rules matched by content
+// for each language, keyed by the language's name in lower case. A language
+// named by an interpreter shebang is given a score higher than any rule can
+// reach.
+func ScoreLanguages(content []byte) map[string]int {
+	scores := map[string]int{}
+	if content == nil {
+		return scores
+	}
+	if language := ShebangLanguage(content); language != "" {
+		scores[strings.ToLower(language)] = len(detectionRules) + 1
+	}
+	for _, rule := range detectionRules {
+		if rule.Pattern.Match(content) {
+			for _, language := range rule.Languages {
+				scores[strings.ToLower(language)]++
+			}
+		}
+	}
+	return scores
+}
+
+// DetectLanguage works out the language of a source from its content. An
+// interpreter shebang is used if present, otherwise every detection rule is
+// tried and the languages in the registry with the most matches are the
+// candidates. An AmbiguousLanguageError is returned if there is more than
+// one candidate.
+func DetectLanguage(content []byte) (*ContainerImage, error) {
+	scores := ScoreLanguages(content)
+	best := 0
+	var candidates []*ContainerImage
+
+	for _, extension := range registryExtensions() {
+		for _, image := range registry[extension] {
+			score := scores[strings.ToLower(image.Name)]
+			if score == 0 || score < best || containsLanguage(candidates, image.Name) {
+				continue
+			}
+			if score > best {
+				best = score
+				candidates = nil
+			}
+			candidates = append(candidates, image)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("unable to detect language; use --lang, --extension or --image to choose one")
+	case 1:
+		return candidates[0], nil
+	default:
+		return nil, &AmbiguousLanguageError{candidates}
+	}
+}
+
+// ResolveCandidates chooses between the candidate images for an extension.
+// A language given with --lang, or the same option in configuration, always
+// wins. Otherwise the candidate with the highest score for content is
+// chosen, falling back to the default candidate if none score or there is a
+// tie. The reasons for the choice are returned for display by --explain.
+//
+// A language that matches none of the candidates is an error if it was given
+// on the command line, as reported by languageOrigin, or if the extension has
+// several candidates. A language set in configuration is otherwise ignored
+// for extensions with a single language, so that a project default doesn't
+// stop sources in other languages from running.
+func ResolveCandidates(extension string, content []byte, language string, languageOrigin string) (*ContainerImage, []string, error) {
+	candidates := LookupCandidatesByExtension(extension)
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("map does not contain key %s", extension)
+	}
+
+	if language != "" {
+		for _, candidate := range candidates {
+			if MatchesLanguage(candidate, language) {
+				return candidate, []string{
+					fmt.Sprintf("%s chosen for .%s by --lang %s", candidate.Name, extension, language),
+				}, nil
+			}
+		}
+		if len(candidates) > 1 || languageOrigin == commandLineOrigin {
+			return nil, nil, fmt.Errorf(
+				"--lang %s does not match any language for .%s: candidates are %s",
+				language, extension, strings.Join(candidateNames(candidates), ", "))
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0], []string{
+			fmt.Sprintf("%s is the only language for .%s", candidates[0].Name, extension),
+		}, nil
+	}
+
+	scores := ScoreLanguages(content)
+	chosen := candidates[0]
+	var reasons []string
+	for _, candidate := range candidates {
+		score := scores[strings.ToLower(candidate.Name)]
+		reasons = append(reasons, fmt.Sprintf("%s (%s:%s) scored %d", candidate.Name, candidate.Image, candidate.Version, score))
+		if score > scores[strings.ToLower(chosen.Name)] {
+			chosen = candidate
+		}
+	}
+
+	if scores[strings.ToLower(chosen.Name)] == 0 {
+		reasons = append(reasons, fmt.Sprintf("no content heuristics matched, using default %s for .%s", chosen.Name, extension))
+	} else {
+		reasons = append(reasons, fmt.Sprintf("%s chosen for .%s by content heuristics", chosen.Name, extension))
+	}
+	return chosen, reasons, nil
+}
+
+// DisplayExplanation prints the image that was chosen and the reasons for
+// choosing it to STDERR so that the output of the program is unaffected. If
+// the language was set outside of the command line, its origin is included.
+func DisplayExplanation(image *ContainerImage, reasons []string, languageOrigin string) {
+	fmt.Fprintf(os.Stderr, "dexec: using %s (%s:%s)\n", image.Name, image.Image, image.Version)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "dexec:   %s\n", reason)
+	}
+	if languageOrigin != "" && languageOrigin != commandLineOrigin {
+		fmt.Fprintf(os.Stderr, "dexec:   --lang was set by %s\n", languageOrigin)
+	}
+}
+
+func candidateNames(candidates []*ContainerImage) []string {
+	var names []string
+	for _, candidate := range candidates {
+		names = append(names, candidate.Name)
+	}
+	return names
+}
+
+// DetectSourceLanguage reads a source relative to dir and detects its
+// language from its content.
+func DetectSourceLanguage(dir string, source string) (*ContainerImage, error) {
+	basename, _ := ExtractBasenameAndPermission(source)
+	content, err := ioutil.ReadFile(filepath.Join(dir, basename))
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := DetectLanguage(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", basename, err)
+	}
+	return image, nil
+}
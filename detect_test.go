@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestShebangLanguage(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+	}{
+		{"#!/usr/bin/env python3\nprint('hello')\n", "Python"},
+		{"#!/bin/bash\necho hello\n", "Bash"},
+		{"#!/usr/bin/env -S node --harmony\n", "JavaScript"},
+		{"#!/usr/bin/ruby2.7 -w\n", "Ruby"},
+		{"#!/usr/bin/env dexec\nint main() {}\n", ""},
+		{"#!/usr/bin/env -S dexec -b -O2\n", ""},
+		{"print('hello')\n", ""},
+	}
+	for _, c := range cases {
+		if got := ShebangLanguage([]byte(c.content)); got != c.want {
+			t.Errorf("ShebangLanguage(%q) %q != %q", c.content, got, c.want)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	cases := []struct {
+		content   string
+		wantImage string
+	}{
+		{"#!/usr/bin/env python3\nprint('hello')\n", "dexec/lang-python"},
+		{"package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n", "dexec/lang-go"},
+		{"#include <iostream>\nint main() {\n\tstd::cout << \"hello\";\n}\n", "dexec/lang-cpp"},
+		{"#include <stdio.h>\nint main() {\n\tprintf(\"hello\");\n}\n", "dexec/lang-c"},
+		{"#include \"foo.h\"\nnamespace foo {\nint bar();\n}\n", "dexec/lang-cpp"},
+		{"#include \"foo.h\"\ntemplate<typename T>\nT max(T a, T b);\n", "dexec/lang-cpp"},
+		{"fn main() {\n    println!(\"hello\");\n}\n", "dexec/lang-rust"},
+		{"<?php\necho 'hello';\n", "dexec/lang-php"},
+	}
+	for _, c := range cases {
+		got, err := DetectLanguage([]byte(c.content))
+		if err != nil {
+			t.Errorf("DetectLanguage(%q) unexpected error %q", c.content, err)
+		} else if got.Image != c.wantImage {
+			t.Errorf("DetectLanguage(%q) %q != %q", c.content, got.Image, c.wantImage)
+		}
+	}
+}
+
+func TestDetectLanguageErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+	}{
+		{
+			"#include \"foo.h\"\n",
+			"language is ambiguous, candidates are C (.c), C++ (.cpp); use --lang or --extension to choose one",
+		},
+		{
+			"hello world\n",
+			"unable to detect language; use --lang, --extension or --image to choose one",
+		},
+	}
+	for _, c := range cases {
+		_, err := DetectLanguage([]byte(c.content))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("DetectLanguage(%q) %v != %q", c.content, err, c.want)
+		}
+	}
+}
+
+func TestResolveCandidates(t *testing.T) {
+	cases := []struct {
+		extension      string
+		content        string
+		language       string
+		languageOrigin string
+		wantName       string
+	}{
+		{"m", "#import <Foundation/Foundation.h>\n@interface Foo\n@end\n", "", "", "Objective C"},
+		{"m", "function y = double(x)\n  y = x * 2;\nendfunction\ndisp(double(2))\n", "", "", "Octave"},
+		{"m", "x = 1\n", "", "", "Objective C"},
+		{"m", "x = 1\n", "octave", commandLineOrigin, "Octave"},
+		{"pl", "use strict;\nmy $x = 1;\nprint $x;\n", "", "", "Perl"},
+		{"pl", ":- initialization(main).\nmain :- write(hello).\n", "", "", "Prolog"},
+		{"h", "#include <vector>\nclass Foo {};\n", "", "", "C++"},
+		{"h", "#include \"foo.h\"\nclass Foo {};\n", "", "", "C++"},
+		{"h", "#include \"foo.h\"\nstd::string foo();\n", "", "", "C++"},
+		{"h", "#include <stddef.h>\nsize_t foo(void);\n", "", "", "C"},
+		{"fs", "[<EntryPoint>]\nlet main argv = 0\n", "", "", "F#"},
+		{"h", "int foo(void);\n", "cpp", commandLineOrigin, "C++"},
+		{"py", "", "", "", "Python"},
+		{"py", "", "octave", ".dexecrc", "Python"},
+		{"py", "", "octave", "environment", "Python"},
+	}
+	for _, c := range cases {
+		got, _, err := ResolveCandidates(c.extension, []byte(c.content), c.language, c.languageOrigin)
+		if err != nil {
+			t.Errorf("ResolveCandidates(%q, %q, %q) unexpected error %q", c.extension, c.content, c.language, err)
+		} else if got.Name != c.wantName {
+			t.Errorf("ResolveCandidates(%q, %q, %q) %q != %q", c.extension, c.content, c.language, got.Name, c.wantName)
+		}
+	}
+
+	errorCases := []struct {
+		extension      string
+		language       string
+		languageOrigin string
+		want           string
+	}{
+		{"m", "Mercury", commandLineOrigin, "--lang Mercury does not match any language for .m: candidates are Objective C, Octave"},
+		{"m", "Mercury", ".dexecrc", "--lang Mercury does not match any language for .m: candidates are Objective C, Octave"},
+		{"fs", "forth", commandLineOrigin, "--lang forth does not match any language for .fs: candidates are F#"},
+		{"py", "octave", commandLineOrigin, "--lang octave does not match any language for .py: candidates are Python"},
+	}
+	for _, c := range errorCases {
+		_, _, err := ResolveCandidates(c.extension, nil, c.language, c.languageOrigin)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("ResolveCandidates(%q, %q, %q) %v != %q", c.extension, c.language, c.languageOrigin, err, c.want)
+		}
+	}
+}
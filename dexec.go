@@ -2,55 +2,138 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"io/ioutil"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/fsouza/go-dockerclient"
 )
 
 // ContainerImage consists of the file extension, Docker image name and Docker
-// image version to use for a given Docker Exec image.
+// image version to use for a given Docker Exec image. If Digest is set the
+// image is pinned to that content digest, which takes precedence over the
+// version when pulling and running the image. Platform, if set, is the
+// os/arch the image is pulled for. If Build is set a customised image is built
+// on top of the image and run in its place. If Command is set the image is run
+// with the command template instead of the dexec entrypoint. Env holds the
+// environment variables the image is run with by default.
 type ContainerImage struct {
 	Name      string
 	Extension string
 	Image     string
 	Version   string
+	Digest    string
+	Platform  string
+	Build     *ImageBuild
+	Command   string
+	Env       []string
 }
 
 const dexecPath = "/tmp/dexec/build"
 const dexecImageTemplate = "%s:%s"
+const dexecDigestTemplate = "%s@%s"
 const dexecVolumeTemplate = "%s/%s:%s/%s"
 
-// ImageFromOptions returns an image from a set of options.
-func ImageFromOptions(options map[OptionType][]string) (image *ContainerImage, err error) {
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Reference returns the reference used for the image in the local Docker
+// repository, which is name@digest for a pinned image and name:version
+// otherwise.
+func (image *ContainerImage) Reference() string {
+	if image.Digest != "" {
+		return fmt.Sprintf(dexecDigestTemplate, image.Image, image.Digest)
+	}
+	return fmt.Sprintf(dexecImageTemplate, image.Image, image.Version)
+}
+
+// DigestMismatchError is returned when the digests of a local image do not
+// include the digest that the image is pinned to.
+type DigestMismatchError struct {
+	Reference   string
+	Digest      string
+	RepoDigests []string
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf(
+		"image %s does not match pinned digest %s (found %s)",
+		e.Reference, e.Digest, strings.Join(e.RepoDigests, ", "))
+}
+
+// ImageFromOptions returns an image from a set of options along with the
+// reasons it was chosen. If the first source has no extension, or there are
+// no sources and the content of STDIN has been buffered, the language is
+// detected from the content instead. Where an extension is shared by more
+// than one language, the candidate is chosen by ResolveCandidates, with
+// languageOrigin the origin of the --lang option.
+func ImageFromOptions(options map[OptionType][]string, languageOrigin string, stdin []byte) (image *ContainerImage, reasons []string, err error) {
 	useExtension := len(options[Extension]) == 1
 	useImage := len(options[Image]) == 1
 
+	var language string
+	if len(options[Language]) == 1 {
+		language = options[Language][0]
+	}
+
 	if useStdin := len(options[Source]) == 0; useStdin {
 		if useExtension {
-			image, err = LookupImageByExtension(options[Extension][0])
+			image, reasons, err = ResolveCandidates(options[Extension][0], stdin, language, languageOrigin)
 		} else if useImage {
 			overrideImage, err := LookupImageByOverride(options[Image][0], "unknown")
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
-			image, err = LookupImageByName(overrideImage.Image)
-			if image != nil {
-				image.Version = overrideImage.Version
+			if image, err = LookupImageByName(overrideImage.Image); err != nil {
+				return nil, nil, err
 			}
+			copied := *image
+			copied.Version = overrideImage.Version
+			copied.Digest = overrideImage.Digest
+			image = &copied
+			reasons = []string{fmt.Sprintf("image %s given with --image", options[Image][0])}
+		} else if language != "" {
+			image, err = LookupImageByLanguage(language)
+			reasons = []string{fmt.Sprintf("language %s given with --lang", language)}
+		} else if stdin != nil {
+			image, err = DetectLanguage(stdin)
+			reasons = []string{"language detected from the content of STDIN"}
 		} else {
 			err = fmt.Errorf("STDIN requested but no extension or image supplied")
 		}
 	} else {
-		if extension := ExtractFileExtension(options[Source][0]); useExtension {
-			image, err = LookupImageByExtension(options[Extension][0])
+		source := options[Source][0]
+		if extension := ExtractFileExtension(source); useExtension {
+			image, reasons, err = ResolveCandidates(options[Extension][0], readSourceContent(options), language, languageOrigin)
 		} else if useImage {
 			image, err = LookupImageByOverride(options[Image][0], extension)
+			reasons = []string{fmt.Sprintf("image %s given with --image", options[Image][0])}
+		} else if extension == "" && language != "" {
+			image, err = LookupImageByLanguage(language)
+			reasons = []string{fmt.Sprintf("language %s given with --lang", language)}
+		} else if extension == "" {
+			image, err = DetectSourceLanguage(RetrievePath(options[TargetDir]), source)
+			reasons = []string{fmt.Sprintf("language detected from the content of %s", source)}
 		} else {
-			image, err = LookupImageByExtension(extension)
+			var content []byte
+			if len(LookupCandidatesByExtension(extension)) > 1 {
+				content = readSourceContent(options)
+			}
+			image, reasons, err = ResolveCandidates(extension, content, language, languageOrigin)
 		}
 	}
-	return image, err
+	return image, reasons, err
+}
+
+// readSourceContent returns the content of the first source, or nil if it
+// cannot be read.
+func readSourceContent(options map[OptionType][]string) []byte {
+	basename, _ := ExtractBasenameAndPermission(options[Source][0])
+	content, err := ioutil.ReadFile(filepath.Join(RetrievePath(options[TargetDir]), basename))
+	if err != nil {
+		return nil
+	}
+	return content
 }
 
 // BuildVolumeArgs takes a base path and returns an array of Docker volume
@@ -70,6 +153,26 @@ func BuildVolumeArgs(path string, targets []string) []string {
 	return volumeArgs
 }
 
+// BuildSourceVolumeArg takes a base path, a source and the extension of the
+// image being used and returns the Docker volume argument for the source.
+// Sources without an extension are mounted with the image's extension added
+// so that the entrypoint can recognise them.
+func BuildSourceVolumeArg(path string, source string, extension string) string {
+	basename, _ := ExtractBasenameAndPermission(source)
+	return fmt.Sprintf(dexecVolumeTemplate, path, basename, dexecPath, AddSourceExtension(source, extension))
+}
+
+// AddSourceExtension returns the name a source is given in the container.
+// This is the source itself unless it has no extension, in which case the
+// supplied extension is added before any permission suffix.
+func AddSourceExtension(source string, extension string) string {
+	if extension == "" || ExtractFileExtension(source) != "" {
+		return source
+	}
+	basename, permission := ExtractBasenameAndPermission(source)
+	return fmt.Sprintf("%s.%s%s", basename, extension, permission)
+}
+
 // ExtractBasenameAndPermission takes an include string and splits it into
 // its file or folder name and the permission string if present or the empty
 // string if not.
@@ -88,95 +191,192 @@ func ExtractBasenameAndPermission(path string) (string, string) {
 }
 
 // FetchImage guarantees a Docker image is availabe in the local repository or
-// returns an error.
-func FetchImage(name string, tag string, update bool, client *docker.Client) error {
-	dockerImage := fmt.Sprintf(dexecImageTemplate, name, tag)
+// returns an error. The image is looked for and pulled under the name given by
+// each mirror in turn before its own name, and the image that is available is
+// returned. A local image built for a platform other than the one asked for
+// counts as missing. When offline an OfflineError is returned for a missing
+// image instead of pulling it. Images pinned to a digest are pulled by that digest
+// and verified against the local image's repository digests, in which case a
+// DigestMismatchError is returned if they don't match.
+func FetchImage(image *ContainerImage, pullOptions PullOptions, update bool, client *docker.Client) (*ContainerImage, error) {
+	if !update {
+		local, inspected, err := FindLocalImage(image, pullOptions.Mirrors, client)
+		if err != nil {
+			return nil, err
+		} else if local != nil && MatchesPlatform(inspected, pullOptions.platformFor(image)) {
+			return local, VerifyImageDigest(local, inspected.RepoDigests)
+		}
+	}
+
+	if pullOptions.Offline {
+		reference := image.Reference()
+		if platform := pullOptions.platformFor(image); platform != "" {
+			reference = fmt.Sprintf("%s (%s)", reference, platform)
+		}
+		return nil, &OfflineError{reference}
+	}
 
-	if _, err := client.InspectImage(dockerImage); update || err != nil {
-		err = client.PullImage(docker.PullImageOptions{
-			Repository: name,
-			Tag:        tag,
-		}, docker.AuthConfiguration{})
+	pulled, err := PullMirroredImage(MirroredImages(image, pullOptions.Mirrors), pullOptions, client)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			log.Fatal(err)
+	inspected, err := client.InspectImage(pulled.Reference())
+	if err != nil {
+		return nil, err
+	}
+	return pulled, VerifyImageDigest(pulled, inspected.RepoDigests)
+}
+
+// FindLocalImage looks for an image in the local repository under the name
+// given by each mirror in turn and then under its own name. It returns the
+// first one found along with its details, or nil if there is none.
+func FindLocalImage(image *ContainerImage, mirrors []RegistryMirror, client *docker.Client) (*ContainerImage, *docker.Image, error) {
+	for _, candidate := range MirroredImages(image, mirrors) {
+		inspected, err := client.InspectImage(candidate.Reference())
+		if err == nil {
+			return candidate, inspected, nil
+		} else if err != docker.ErrNoSuchImage {
+			return nil, nil, err
 		}
+	}
+	return nil, nil, nil
+}
 
-		if _, err = client.InspectImage(dockerImage); err != nil {
-			return err
+// VerifyImageDigest checks that one of the repository digests of a local
+// image matches the digest the image is pinned to. Images that are not
+// pinned always pass.
+func VerifyImageDigest(image *ContainerImage, repoDigests []string) error {
+	if image.Digest == "" {
+		return nil
+	}
+	for _, repoDigest := range repoDigests {
+		if strings.HasSuffix(repoDigest, "@"+image.Digest) {
+			return nil
 		}
 	}
-	return nil
+	return &DigestMismatchError{image.Reference(), image.Digest, repoDigests}
 }
 
 var innerMap = map[string]*ContainerImage{
-	"c":      {"C", "c", "dexec/lang-c", "1.0.2"},
-	"clj":    {"Clojure", "clj", "dexec/lang-clojure", "1.0.1"},
-	"coffee": {"CoffeeScript", "coffee", "dexec/lang-coffee", "1.0.2"},
-	"cpp":    {"C++", "cpp", "dexec/lang-cpp", "1.0.2"},
-	"cs":     {"C#", "cs", "dexec/lang-csharp", "1.0.2"},
-	"d":      {"D", "d", "dexec/lang-d", "1.0.1"},
-	"erl":    {"Erlang", "erl", "dexec/lang-erlang", "1.0.1"},
-	"fs":     {"F#", "fs", "dexec/lang-fsharp", "1.0.2"},
-	"go":     {"Go", "go", "dexec/lang-go", "1.0.1"},
-	"groovy": {"Groovy", "groovy", "dexec/lang-groovy", "1.0.1"},
-	"hs":     {"Haskell", "hs", "dexec/lang-haskell", "1.0.1"},
-	"java":   {"Java", "java", "dexec/lang-java", "1.0.3"},
-	"lisp":   {"Lisp", "lisp", "dexec/lang-lisp", "1.0.1"},
-	"lua":    {"Lua", "lua", "dexec/lang-lua", "1.0.1"},
-	"js":     {"JavaScript", "js", "dexec/lang-node", "1.0.2"},
-	"nim":    {"Nim", "nim", "dexec/lang-nim", "1.0.1"},
-	"m":      {"Objective C", "m", "dexec/lang-objc", "1.0.2"},
-	"ml":     {"OCaml", "ml", "dexec/lang-ocaml", "1.0.1"},
-	"p6":     {"Perl 6", "p6", "dexec/lang-perl6", "1.0.1"},
-	"pl":     {"Perl", "pl", "dexec/lang-perl", "1.0.2"},
-	"php":    {"PHP", "php", "dexec/lang-php", "1.0.1"},
-	"py":     {"Python", "py", "dexec/lang-python", "1.0.2"},
-	"r":      {"R", "r", "dexec/lang-r", "1.0.1"},
-	"rkt":    {"Racket", "rkt", "dexec/lang-racket", "1.0.1"},
-	"rb":     {"Ruby", "rb", "dexec/lang-ruby", "1.0.2"},
-	"rs":     {"Rust", "rs", "dexec/lang-rust", "1.0.1"},
-	"scala":  {"Scala", "scala", "dexec/lang-scala", "1.0.1"},
-	"sh":     {"Bash", "sh", "dexec/lang-bash", "1.0.1"},
-}
-
-// LookupImageByExtension returns the image for a given extension.
+	"c":      {Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2"},
+	"clj":    {Name: "Clojure", Extension: "clj", Image: "dexec/lang-clojure", Version: "1.0.1"},
+	"coffee": {Name: "CoffeeScript", Extension: "coffee", Image: "dexec/lang-coffee", Version: "1.0.2"},
+	"cpp":    {Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2"},
+	"cs":     {Name: "C#", Extension: "cs", Image: "dexec/lang-csharp", Version: "1.0.2"},
+	"d":      {Name: "D", Extension: "d", Image: "dexec/lang-d", Version: "1.0.1"},
+	"erl":    {Name: "Erlang", Extension: "erl", Image: "dexec/lang-erlang", Version: "1.0.1"},
+	"fs":     {Name: "F#", Extension: "fs", Image: "dexec/lang-fsharp", Version: "1.0.2"},
+	"go":     {Name: "Go", Extension: "go", Image: "dexec/lang-go", Version: "1.0.1"},
+	"groovy": {Name: "Groovy", Extension: "groovy", Image: "dexec/lang-groovy", Version: "1.0.1"},
+	"hs":     {Name: "Haskell", Extension: "hs", Image: "dexec/lang-haskell", Version: "1.0.1"},
+	"java":   {Name: "Java", Extension: "java", Image: "dexec/lang-java", Version: "1.0.3"},
+	"lisp":   {Name: "Lisp", Extension: "lisp", Image: "dexec/lang-lisp", Version: "1.0.1"},
+	"lua":    {Name: "Lua", Extension: "lua", Image: "dexec/lang-lua", Version: "1.0.1"},
+	"js":     {Name: "JavaScript", Extension: "js", Image: "dexec/lang-node", Version: "1.0.2"},
+	"nim":    {Name: "Nim", Extension: "nim", Image: "dexec/lang-nim", Version: "1.0.1"},
+	"m":      {Name: "Objective C", Extension: "m", Image: "dexec/lang-objc", Version: "1.0.2"},
+	"ml":     {Name: "OCaml", Extension: "ml", Image: "dexec/lang-ocaml", Version: "1.0.1"},
+	"p6":     {Name: "Perl 6", Extension: "p6", Image: "dexec/lang-perl6", Version: "1.0.1"},
+	"pl":     {Name: "Perl", Extension: "pl", Image: "dexec/lang-perl", Version: "1.0.2"},
+	"php":    {Name: "PHP", Extension: "php", Image: "dexec/lang-php", Version: "1.0.1"},
+	"py":     {Name: "Python", Extension: "py", Image: "dexec/lang-python", Version: "1.0.2"},
+	"r":      {Name: "R", Extension: "r", Image: "dexec/lang-r", Version: "1.0.1"},
+	"rkt":    {Name: "Racket", Extension: "rkt", Image: "dexec/lang-racket", Version: "1.0.1"},
+	"rb":     {Name: "Ruby", Extension: "rb", Image: "dexec/lang-ruby", Version: "1.0.2"},
+	"rs":     {Name: "Rust", Extension: "rs", Image: "dexec/lang-rust", Version: "1.0.1"},
+	"scala":  {Name: "Scala", Extension: "scala", Image: "dexec/lang-scala", Version: "1.0.1"},
+	"sh":     {Name: "Bash", Extension: "sh", Image: "dexec/lang-bash", Version: "1.0.1"},
+}
+
+// innerCandidates holds the languages for extensions that are shared by more
+// than one language with a dexec image. The first candidate is the default.
+// Octave and Prolog join .m and .pl as recipes. Mercury (.m) and Forth (.fs)
+// have no image to run them with, so they are left to the language registry.
+var innerCandidates = map[string][]*ContainerImage{
+	"h": {
+		{Name: "C", Extension: "h", Image: "dexec/lang-c", Version: "1.0.2"},
+		{Name: "C++", Extension: "h", Image: "dexec/lang-cpp", Version: "1.0.2"},
+	},
+}
+
+// LookupImageByExtension returns the default image for a given extension.
 func LookupImageByExtension(key string) (*ContainerImage, error) {
-	if v, ok := innerMap[key]; ok {
-		return v, nil
+	if v := LookupCandidatesByExtension(key); len(v) > 0 {
+		return v[0], nil
 	}
 	return nil, fmt.Errorf("map does not contain key %s", key)
 }
 
+// LookupCandidatesByExtension returns every image for a given extension, with
+// the default first.
+func LookupCandidatesByExtension(key string) []*ContainerImage {
+	return registry[key]
+}
+
 // LookupImageByName returns the image for a given image name.
 func LookupImageByName(name string) (*ContainerImage, error) {
-	for _, v := range innerMap {
-		if v.Image == name {
-			return v, nil
+	for _, extension := range registryExtensions() {
+		for _, v := range registry[extension] {
+			if v.Image == name {
+				return v, nil
+			}
 		}
 	}
 	return nil, fmt.Errorf("map does not contain image with name %s", name)
 }
 
+// LookupImageByLanguage returns the image for a language given either by its
+// name, compared case-insensitively, or by one of its extensions.
+func LookupImageByLanguage(language string) (*ContainerImage, error) {
+	for _, extension := range registryExtensions() {
+		for _, v := range registry[extension] {
+			if MatchesLanguage(v, language) {
+				return v, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("map does not contain language %s", language)
+}
+
+// MatchesLanguage returns true if the image is for the given language, which
+// may be either the language's name or one of its extensions.
+func MatchesLanguage(image *ContainerImage, language string) bool {
+	if strings.EqualFold(image.Name, language) {
+		return true
+	}
+	if candidates := LookupCandidatesByExtension(language); len(candidates) > 0 {
+		return strings.EqualFold(candidates[0].Name, image.Name)
+	}
+	return false
+}
+
 // LookupImageByOverride takes an image that has been specified by the user
 // to use instead of the one in the extension map. This function returns a
-// DexecImage struct containing the image name & version, as well as the
-// file extension that was passed in.
+// DexecImage struct containing the image name, version and digest, as well as
+// the file extension that was passed in. The image may be given as name,
+// name:version, name@digest or name:version@digest.
 func LookupImageByOverride(image string, extension string) (*ContainerImage, error) {
-	patternImage := regexp.MustCompile(`(.*):(.*)`)
+	patternImage := regexp.MustCompile(`^([^@]+?)(?::([^:/@]+))?(?:@([^@]+))?$`)
 	imageMatch := patternImage.FindStringSubmatch(image)
-	if len(imageMatch) > 0 {
-		return &ContainerImage{
-			"Unknown",
-			extension,
-			imageMatch[1],
-			imageMatch[2],
-		}, nil
+	if len(imageMatch) == 0 {
+		return nil, fmt.Errorf("invalid image %s", image)
+	}
+
+	version := imageMatch[2]
+	if version == "" {
+		version = "latest"
+	}
+	digest := imageMatch[3]
+	if digest != "" && !digestPattern.MatchString(digest) {
+		return nil, fmt.Errorf("invalid digest %s in image %s", digest, image)
 	}
+
 	return &ContainerImage{
-		"Unknown",
-		extension,
-		image,
-		"latest",
+		Name:      "Unknown",
+		Extension: extension,
+		Image:     imageMatch[1],
+		Version:   version,
+		Digest:    digest,
 	}, nil
 }
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
 )
 
 func TestBuildVolumeArgs(t *testing.T) {
@@ -21,6 +26,28 @@ func TestBuildVolumeArgs(t *testing.T) {
 	}
 }
 
+func TestBuildSourceVolumeArg(t *testing.T) {
+	cases := []struct {
+		path       string
+		source     string
+		extension  string
+		wantVolume string
+	}{
+		{"/foo", "bar.py", "py", "/foo/bar.py:/tmp/dexec/build/bar.py"},
+		{"/foo", "bar", "py", "/foo/bar:/tmp/dexec/build/bar.py"},
+		{"/foo", "bar:ro", "py", "/foo/bar:/tmp/dexec/build/bar.py:ro"},
+		{"/foo", "bar", "", "/foo/bar:/tmp/dexec/build/bar"},
+	}
+	for _, c := range cases {
+		gotVolume := BuildSourceVolumeArg(c.path, c.source, c.extension)
+		if gotVolume != c.wantVolume {
+			t.Errorf("BuildSourceVolumeArg(%q, %q, %q) %q != %q", c.path, c.source, c.extension, gotVolume, c.wantVolume)
+		}
+	}
+}
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
 func TestLookupImageByOverride(t *testing.T) {
 	cases := []struct {
 		image         string
@@ -28,11 +55,15 @@ func TestLookupImageByOverride(t *testing.T) {
 		wantExtension string
 		wantImage     string
 		wantVersion   string
+		wantDigest    string
 		wantError     error
 	}{
-		{"dexec/cpp", "c", "c", "dexec/cpp", "latest", nil},
-		{"dexec/some-language", "ext", "ext", "dexec/some-language", "latest", nil},
-		{"dexec/some-language:1.2.3", "ext", "ext", "dexec/some-language", "1.2.3", nil},
+		{"dexec/cpp", "c", "c", "dexec/cpp", "latest", "", nil},
+		{"dexec/some-language", "ext", "ext", "dexec/some-language", "latest", "", nil},
+		{"dexec/some-language:1.2.3", "ext", "ext", "dexec/some-language", "1.2.3", "", nil},
+		{"localhost:5000/some-language", "ext", "ext", "localhost:5000/some-language", "latest", "", nil},
+		{"dexec/some-language@" + testDigest, "ext", "ext", "dexec/some-language", "latest", testDigest, nil},
+		{"dexec/some-language:1.2.3@" + testDigest, "ext", "ext", "dexec/some-language", "1.2.3", testDigest, nil},
 	}
 	for _, c := range cases {
 		got, err := LookupImageByOverride(c.image, c.extension)
@@ -42,6 +73,8 @@ func TestLookupImageByOverride(t *testing.T) {
 			t.Errorf("LookupImageByOverride(%q, %q) %q != %q", c.image, c.extension, got.Extension, c.wantExtension)
 		} else if got.Version != c.wantVersion {
 			t.Errorf("LookupImageByOverride(%q, %q) %q != %q", c.image, c.extension, got.Version, c.wantVersion)
+		} else if got.Digest != c.wantDigest {
+			t.Errorf("LookupImageByOverride(%q, %q) %q != %q", c.image, c.extension, got.Digest, c.wantDigest)
 		} else if err != c.wantError {
 			t.Errorf("LookupImageByOverride(%q, %q) %q != %q", c.image, c.extension, err, c.wantError)
 		}
@@ -98,3 +131,152 @@ func TestLookupImageByExtension(t *testing.T) {
 		}
 	}
 }
+
+func TestLookupImageByLanguage(t *testing.T) {
+	cases := []struct {
+		language  string
+		wantImage string
+	}{
+		{"Python", "dexec/lang-python"},
+		{"c++", "dexec/lang-cpp"},
+		{"cpp", "dexec/lang-cpp"},
+		{"Objective C", "dexec/lang-objc"},
+	}
+	for _, c := range cases {
+		got, err := LookupImageByLanguage(c.language)
+		if err != nil {
+			t.Errorf("LookupImageByLanguage(%q) unexpected error %q", c.language, err)
+		} else if got.Image != c.wantImage {
+			t.Errorf("LookupImageByLanguage(%q) %q != %q", c.language, got.Image, c.wantImage)
+		}
+	}
+}
+
+func TestLookupImageByOverrideInvalidDigest(t *testing.T) {
+	image := "dexec/some-language@sha256:abc"
+	_, err := LookupImageByOverride(image, "ext")
+	if want := "invalid digest sha256:abc in image " + image; err == nil || err.Error() != want {
+		t.Errorf("LookupImageByOverride(%q) %v != %q", image, err, want)
+	}
+}
+
+func TestReference(t *testing.T) {
+	cases := []struct {
+		image ContainerImage
+		want  string
+	}{
+		{ContainerImage{Image: "dexec/lang-c", Version: "1.0.2"}, "dexec/lang-c:1.0.2"},
+		{ContainerImage{Image: "dexec/lang-c", Version: "1.0.2", Digest: testDigest}, "dexec/lang-c@" + testDigest},
+	}
+	for _, c := range cases {
+		if got := c.image.Reference(); got != c.want {
+			t.Errorf("Reference() %q != %q", got, c.want)
+		}
+	}
+}
+
+func TestVerifyImageDigest(t *testing.T) {
+	pinned := &ContainerImage{Image: "dexec/lang-c", Version: "1.0.2", Digest: testDigest}
+	other := "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+	cases := []struct {
+		image       *ContainerImage
+		repoDigests []string
+		wantError   bool
+	}{
+		{&ContainerImage{Image: "dexec/lang-c", Version: "1.0.2"}, nil, false},
+		{pinned, []string{"dexec/lang-c@" + testDigest}, false},
+		{pinned, []string{"docker.io/dexec/lang-c@" + other, "docker.io/dexec/lang-c@" + testDigest}, false},
+		{pinned, []string{"dexec/lang-c@" + other}, true},
+		{pinned, nil, true},
+	}
+	for _, c := range cases {
+		err := VerifyImageDigest(c.image, c.repoDigests)
+		if _, isMismatch := err.(*DigestMismatchError); isMismatch != c.wantError {
+			t.Errorf("VerifyImageDigest(%q, %q) unexpected result %v", c.image.Reference(), c.repoDigests, err)
+		}
+	}
+}
+
+func TestFetchImageOffline(t *testing.T) {
+	var pulls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/dexec/lang-c:1.0.2/json"):
+			w.Write([]byte(`{"Id":"sha256:abc","RepoDigests":[]}`))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+		default:
+			pulls++
+			http.Error(w, `{"message":"network unreachable"}`, http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullOptions := PullOptions{Offline: true}
+
+	present := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"}
+	if got, err := FetchImage(present, pullOptions, false, client); err != nil || got.Reference() != present.Reference() {
+		t.Errorf("FetchImage(%q) offline %v, %v", present.Reference(), got, err)
+	}
+
+	missing := &ContainerImage{Name: "Go", Image: "dexec/lang-go", Version: "1.0.1"}
+	_, err = FetchImage(missing, pullOptions, false, client)
+	if offlineErr, ok := err.(*OfflineError); !ok || offlineErr.Reference != "dexec/lang-go:1.0.1" {
+		t.Errorf("FetchImage(%q) offline unexpected error %v", missing.Reference(), err)
+	} else if !strings.Contains(err.Error(), "dexec/lang-go:1.0.1") {
+		t.Errorf("FetchImage(%q) offline error %q does not name the image", missing.Reference(), err)
+	}
+	if pulls > 0 {
+		t.Errorf("FetchImage() offline made %d pull requests", pulls)
+	}
+}
+
+func TestFetchImagePlatform(t *testing.T) {
+	var platforms []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/images/create"):
+			platforms = append(platforms, r.URL.Query().Get("platform"))
+			w.Write([]byte(`{"status":"Downloaded newer image"}` + "\r\n"))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			w.Write([]byte(`{"Id":"sha256:abc","Os":"linux","Architecture":"amd64"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"}
+
+	cases := []struct {
+		pullOptions   PullOptions
+		wantPlatforms []string
+	}{
+		{PullOptions{}, nil},
+		{PullOptions{Platform: "linux/amd64"}, nil},
+		{PullOptions{Platform: "linux/arm64"}, []string{"linux/arm64"}},
+	}
+	for _, c := range cases {
+		platforms = nil
+		if _, err := FetchImage(image, c.pullOptions, false, client); err != nil {
+			t.Errorf("FetchImage() for platform %q unexpected error %v", c.pullOptions.Platform, err)
+		}
+		if !reflect.DeepEqual(platforms, c.wantPlatforms) {
+			t.Errorf("FetchImage() for platform %q pulled %q", c.pullOptions.Platform, platforms)
+		}
+	}
+
+	_, err = FetchImage(image, PullOptions{Platform: "linux/arm64", Offline: true}, false, client)
+	if offlineErr, ok := err.(*OfflineError); !ok || offlineErr.Reference != "dexec/lang-c:1.0.2 (linux/arm64)" {
+		t.Errorf("FetchImage() offline for another platform unexpected error %v", err)
+	}
+}
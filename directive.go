@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// directiveLineLimit is the number of lines at the start of each source
+// that are searched for directives.
+const directiveLineLimit = 10
+
+// directiveForbidden contains the configurable option types that can't be
+// set in a directive, so that a source can't loosen the isolation or the
+// resource limits it is run with, or name the image, toolchain version,
+// platform or registry it is run from. A source may still choose one of the
+// languages in the registry with --lang or --extension. Image builds run with
+// the default network whatever the options are.
+var directiveForbidden = map[OptionType]bool{
+	Image:           true,
+	Dockerfile:      true,
+	LanguageVersion: true,
+	Platform:        true,
+	Mirror:          true,
+	RegistryAuth:    true,
+	UpdatePolicy:    true,
+	Memory:          true,
+	MemorySwap:      true,
+	CPUs:            true,
+	PidsLimit:       true,
+	Ulimit:          true,
+	StorageSize:     true,
+	Network:         true,
+	DNS:             true,
+	AddHost:         true,
+	CapDrop:         true,
+	CapAdd:          true,
+	SecurityOpt:     true,
+	ReadOnlyFlag:    true,
+	Runtime:         true,
+	Sandbox:         true,
+	EnvFile:         true,
+}
+
+var shebangDirectivePattern = regexp.MustCompile(`^#!(?:\S*/)?(?:env\s+(?:-S\s+)?)?(?:\S*/)?dexec(?:\s+(.*))?$`)
+var commentDirectivePattern = regexp.MustCompile(`^\s*(?://|#|--|;+|%|/\*|\(\*|\{-)\s*dexec:\s*(.*?)\s*(?:\*/|\*\)|-\})?\s*$`)
+
+// ExtractDirectives reads the first lines of a source and returns the dexec
+// arguments given in a shebang such as '#!/usr/bin/env -S dexec -b -O2' or
+// in a comment such as '// dexec: -i data.txt -t 10'.
+func ExtractDirectives(reader io.Reader) ([]string, error) {
+	var args []string
+	scanner := bufio.NewScanner(reader)
+
+	for line := 1; line <= directiveLineLimit && scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		var match []string
+		if line == 1 {
+			match = shebangDirectivePattern.FindStringSubmatch(text)
+		}
+		if match == nil {
+			match = commentDirectivePattern.FindStringSubmatch(text)
+		}
+		if match == nil {
+			continue
+		}
+
+		lineArgs, err := SplitArgs(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s", line, err)
+		}
+		args = append(args, lineArgs...)
+	}
+	return args, scanner.Err()
+}
+
+// ParseDirectiveArgs converts the arguments found in a source directive to a
+// map of option types to their values. Only options that can be set in a
+// project configuration file are allowed, apart from those in
+// directiveForbidden and those that read from the host, i.e. environment
+// files, variables passed through from the host environment and included
+// paths outside the source directory.
+func ParseDirectiveArgs(args []string) (map[OptionType][]string, error) {
+	options := map[OptionType][]string{}
+
+	for len(args) > 0 {
+		var next string
+		if len(args) > 1 {
+			next = args[1]
+		}
+
+		optionType, optionValue, chomped, err := ArgToOption(args[0], next)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := optionNames[optionType]; !ok || directiveForbidden[optionType] {
+			return nil, fmt.Errorf("option not allowed in directive: %s", args[0])
+		}
+		if chomped > len(args) {
+			return nil, fmt.Errorf("missing value for option: %s", args[0])
+		}
+		if optionType == Env && !strings.Contains(optionValue, "=") {
+			return nil, fmt.Errorf("host variables can't be passed through in a directive: %s", optionValue)
+		}
+		if optionType == Include && !isRelativeInclude(optionValue) {
+			return nil, fmt.Errorf("included paths in a directive must be within the source directory: %s", optionValue)
+		}
+
+		options[optionType] = append(options[optionType], optionValue)
+		args = args[chomped:]
+	}
+	return options, nil
+}
+
+// isRelativeInclude returns true if an included path, less any permission
+// suffix, is relative and doesn't climb out of the directory it is relative
+// to with '..'.
+func isRelativeInclude(include string) bool {
+	name := strings.TrimSuffix(strings.TrimSuffix(include, ":ro"), ":rw")
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || filepath.VolumeName(name) != "" {
+		return false
+	}
+	for _, element := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// SourceDirectiveOptions reads the directives from each of the sources in
+// dir and returns them as a single option layer. Values for options that may
+// be given more than once are combined, for all others the first source to
+// set the option wins.
+func SourceDirectiveOptions(dir string, sources []string) (OptionLayer, error) {
+	layer := OptionLayer{
+		Origin:  "directives",
+		Options: map[OptionType][]string{},
+	}
+	var names []string
+
+	for _, source := range sources {
+		basename, _ := ExtractBasenameAndPermission(source)
+		file, err := os.Open(filepath.Join(dir, basename))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return layer, err
+		}
+
+		args, err := ExtractDirectives(file)
+		file.Close()
+		if err != nil {
+			return layer, fmt.Errorf("%s:%s", basename, err)
+		}
+
+		options, err := ParseDirectiveArgs(args)
+		if err != nil {
+			return layer, fmt.Errorf("%s: %s", basename, err)
+		}
+		if len(options) == 0 {
+			continue
+		}
+
+		for optionType, values := range options {
+			if _, ok := layer.Options[optionType]; !ok || optionRepeatable[optionType] {
+				layer.Options[optionType] = append(layer.Options[optionType], values...)
+			}
+		}
+		names = append(names, basename)
+	}
+
+	if len(names) > 0 {
+		layer.Origin = fmt.Sprintf("directives (%s)", strings.Join(names, ", "))
+	}
+	return layer, nil
+}
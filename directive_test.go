@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractDirectives(t *testing.T) {
+	cases := []struct {
+		source string
+		want   []string
+	}{
+		{"#!/usr/bin/env dexec\nint main() {}\n", nil},
+		{"#!/usr/bin/env -S dexec -b -O2\nint main() {}\n", []string{"-b", "-O2"}},
+		{"#!/usr/local/bin/dexec -t 5\n", []string{"-t", "5"}},
+		{"// dexec: -i data.txt -t 10\nint main() {}\n", []string{"-i", "data.txt", "-t", "10"}},
+		{"#!/usr/bin/env dexec\n# dexec: -a 'hello world'\n", []string{"-a", "hello world"}},
+		{"/* dexec: -b -std=c++17 */\n-- dexec: -a foo\n", []string{"-b", "-std=c++17", "-a", "foo"}},
+		{"x\nx\nx\nx\nx\nx\nx\nx\nx\nx\n// dexec: -t 10\n", nil},
+		{"// not a dexec: directive\n", nil},
+	}
+	for _, c := range cases {
+		got, err := ExtractDirectives(strings.NewReader(c.source))
+		if err != nil {
+			t.Errorf("ExtractDirectives(%q) unexpected error %q", c.source, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ExtractDirectives(%q) %q != %q", c.source, got, c.want)
+		}
+	}
+}
+
+func TestExtractDirectivesError(t *testing.T) {
+	_, err := ExtractDirectives(strings.NewReader("package main\n// dexec: -a 'foo\n"))
+	if want := "2: unterminated quote in \"-a 'foo\""; err == nil || err.Error() != want {
+		t.Errorf("ExtractDirectives %v != %q", err, want)
+	}
+}
+
+func TestParseDirectiveArgs(t *testing.T) {
+	cases := []struct {
+		args      []string
+		want      map[OptionType][]string
+		wantError string
+	}{
+		{
+			[]string{"-b", "-O2", "--include=data.txt", "-t", "10"},
+			map[OptionType][]string{BuildArg: {"-O2"}, Include: {"data.txt"}, Timeout: {"10"}},
+			"",
+		},
+		{[]string{"foo.cpp"}, nil, "option not allowed in directive: foo.cpp"},
+		{[]string{"-C", "foo"}, nil, "option not allowed in directive: -C"},
+		{[]string{"--network", "host"}, nil, "option not allowed in directive: --network"},
+		{[]string{"-m", "python=evil/python"}, nil, "option not allowed in directive: -m"},
+		{[]string{"--dockerfile", "/home/user/Dockerfile"}, nil, "option not allowed in directive: --dockerfile"},
+		{[]string{"--memory", "64g"}, nil, "option not allowed in directive: --memory"},
+		{[]string{"--memory-swap=-1"}, nil, "option not allowed in directive: --memory-swap=-1"},
+		{[]string{"--cpus", "64"}, nil, "option not allowed in directive: --cpus"},
+		{[]string{"--pids-limit", "1000000"}, nil, "option not allowed in directive: --pids-limit"},
+		{[]string{"--ulimit", "nproc=1000000"}, nil, "option not allowed in directive: --ulimit"},
+		{[]string{"--storage-size", "1t"}, nil, "option not allowed in directive: --storage-size"},
+		{[]string{"-E", "DEBUG=1"}, map[OptionType][]string{Env: {"DEBUG=1"}}, ""},
+		{[]string{"-E", "AWS_SECRET_ACCESS_KEY"}, nil, "host variables can't be passed through in a directive: AWS_SECRET_ACCESS_KEY"},
+		{[]string{"--env-file", "/etc/environment"}, nil, "option not allowed in directive: --env-file"},
+		{[]string{"--lang-version", "python=3.12"}, nil, "option not allowed in directive: --lang-version"},
+		{[]string{"--platform=linux/arm64"}, nil, "option not allowed in directive: --platform=linux/arm64"},
+		{[]string{"--mirror", "dexec/=evil.example.com/dexec/"}, nil, "option not allowed in directive: --mirror"},
+		{[]string{"--registry-auth", "evil.example.com=user:password"}, nil, "option not allowed in directive: --registry-auth"},
+		{[]string{"--update-policy", "always"}, nil, "option not allowed in directive: --update-policy"},
+		{[]string{"-i", "data/input.txt:ro"}, map[OptionType][]string{Include: {"data/input.txt:ro"}}, ""},
+		{[]string{"-i", "/etc/passwd"}, nil, "included paths in a directive must be within the source directory: /etc/passwd"},
+		{[]string{"-i", "/etc:ro"}, nil, "included paths in a directive must be within the source directory: /etc:ro"},
+		{[]string{"--include=../secrets"}, nil, "included paths in a directive must be within the source directory: ../secrets"},
+		{[]string{"-i", "data/../../.ssh/id_rsa:ro"}, nil, "included paths in a directive must be within the source directory: data/../../.ssh/id_rsa:ro"},
+		{[]string{"-i", `..\secrets`}, nil, `included paths in a directive must be within the source directory: ..\secrets`},
+		{[]string{"-b"}, nil, "missing value for option: -b"},
+		{[]string{"--bad"}, nil, "unknown option: --bad"},
+	}
+	for _, c := range cases {
+		got, err := ParseDirectiveArgs(c.args)
+		if c.wantError != "" {
+			if err == nil || err.Error() != c.wantError {
+				t.Errorf("ParseDirectiveArgs(%q) %v != %q", c.args, err, c.wantError)
+			}
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseDirectiveArgs(%q) %q != %q", c.args, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// The labels that mark a local image as a dexec language image. The language
+// label is required, the extensions are separated by commas or spaces and the
+// version is used to choose between several images for the same language.
+const (
+	labelLanguage   = "io.dexec.language"
+	labelExtensions = "io.dexec.extensions"
+	labelVersion    = "io.dexec.version"
+)
+
+// discoverTimeout is how long dexec waits for the Docker host to list the
+// labelled images before carrying on without them.
+const discoverTimeout = 5 * time.Second
+
+// labelledImage is a local image with a language label along with the tag it
+// is used under.
+type labelledImage struct {
+	entry   LanguageEntry
+	version string
+	created int64
+}
+
+// DiscoverLanguageEntries returns a language entry for each language labelled
+// on the given local images. The entry uses the image under its first tag.
+// Where several images are labelled with the same language, the one with the
+// highest io.dexec.version wins, then the most recently created. Untagged
+// images and images with invalid labels are skipped with a notice.
+func DiscoverLanguageEntries(images []docker.APIImages, notices io.Writer) []LanguageEntry {
+	chosen := map[string]labelledImage{}
+	for _, image := range images {
+		name := strings.TrimSpace(image.Labels[labelLanguage])
+		if name == "" {
+			continue
+		}
+
+		var tags []string
+		for _, tag := range image.RepoTags {
+			if tag != "<none>:<none>" {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		sort.Strings(tags)
+		repository := repositoryName(tags[0])
+
+		extensions := strings.FieldsFunc(image.Labels[labelExtensions], func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+		valid := true
+		for i, extension := range extensions {
+			extensions[i] = strings.TrimPrefix(extension, ".")
+			if extensions[i] == "" || strings.ContainsAny(extensions[i], ".\t") {
+				fmt.Fprintf(notices, "dexec: ignoring %s, invalid %s label %q\n", tags[0], labelExtensions, image.Labels[labelExtensions])
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		candidate := labelledImage{
+			entry: LanguageEntry{
+				Name:       name,
+				Extensions: extensions,
+				Image:      repository,
+				Version:    strings.TrimPrefix(tags[0], repository+":"),
+			},
+			version: image.Labels[labelVersion],
+			created: image.Created,
+		}
+		key := strings.ToLower(name)
+		if current, ok := chosen[key]; !ok || candidate.newerThan(current) {
+			chosen[key] = candidate
+		}
+	}
+
+	var entries []LanguageEntry
+	for _, candidate := range chosen {
+		entries = append(entries, candidate.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	return entries
+}
+
+func (image labelledImage) newerThan(other labelledImage) bool {
+	if order := compareVersions(image.version, other.version); order != 0 {
+		return order > 0
+	}
+	return image.created > other.created
+}
+
+// compareVersions compares two dot-separated versions part by part,
+// numerically where both parts are numbers, returning -1, 0 or 1.
+func compareVersions(a string, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var partA, partB string
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+		numberA, errA := strconv.Atoi(partA)
+		numberB, errB := strconv.Atoi(partB)
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			if numberA < numberB {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && partA != partB:
+			if partA < partB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// LoadLabelledImages merges the local images labelled as dexec language
+// images into the registry. A labelled image whose language has the name of
+// a language already in the registry replaces that language's image, and
+// adds its extensions to it, while one for a new language adds it. New
+// languages must be labelled with their extensions. This is done before the
+// user's language registry file is loaded, which therefore takes precedence.
+func LoadLabelledImages(client *docker.Client, notices io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTimeout)
+	defer cancel()
+	images, err := client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{"label": {labelLanguage}},
+		Context: ctx,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range DiscoverLanguageEntries(images, notices) {
+		if len(entry.Extensions) == 0 && len(extensionsForName(registry, entry.Name)) == 0 {
+			fmt.Fprintf(notices, "dexec: ignoring %s:%s, new language %s has no %s label\n", entry.Image, entry.Version, entry.Name, labelExtensions)
+			continue
+		}
+		merged, err := MergeLanguageEntries(registry, []LanguageEntry{entry})
+		if err != nil {
+			return err
+		}
+		registry = merged
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestDiscoverLanguageEntries(t *testing.T) {
+	images := []docker.APIImages{
+		{RepoTags: []string{"corp/python:3.8"}, Created: 100, Labels: map[string]string{labelLanguage: "Python", labelVersion: "3.8.2"}},
+		{RepoTags: []string{"corp/python:3.12", "corp/python:latest"}, Created: 50, Labels: map[string]string{labelLanguage: "python", labelVersion: "3.12.1"}},
+		{RepoTags: []string{"corp/zig:0.7"}, Labels: map[string]string{labelLanguage: "Zig", labelExtensions: ".zig, zon"}},
+		{RepoTags: []string{"corp/kotlin:1.4"}, Created: 10, Labels: map[string]string{labelLanguage: "Kotlin", labelExtensions: "kt"}},
+		{RepoTags: []string{"corp/kotlin:1.5"}, Created: 20, Labels: map[string]string{labelLanguage: "Kotlin", labelExtensions: "kt"}},
+		{RepoTags: []string{"<none>:<none>"}, Labels: map[string]string{labelLanguage: "Julia", labelExtensions: "jl"}},
+		{RepoTags: []string{"corp/bad:1"}, Labels: map[string]string{labelLanguage: "Bad", labelExtensions: "a.b"}},
+		{RepoTags: []string{"ubuntu:18.04"}},
+	}
+	want := []LanguageEntry{
+		{Name: "Kotlin", Extensions: []string{"kt"}, Image: "corp/kotlin", Version: "1.5"},
+		{Name: "python", Extensions: []string{}, Image: "corp/python", Version: "3.12"},
+		{Name: "Zig", Extensions: []string{"zig", "zon"}, Image: "corp/zig", Version: "0.7"},
+	}
+
+	var notices bytes.Buffer
+	got := DiscoverLanguageEntries(images, &notices)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiscoverLanguageEntries() %+v != %+v", got, want)
+	}
+	if !strings.Contains(notices.String(), "ignoring corp/bad:1") {
+		t.Errorf("DiscoverLanguageEntries() notices %q", notices.String())
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"3.12.1", "3.8.2", 1},
+		{"1.0", "1.0.0", -1},
+		{"1.0.2", "1.0.2", 0},
+		{"", "1", -1},
+		{"1.0-beta", "1.0-alpha", 1},
+	}
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) %d != %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestLoadLabelledImages(t *testing.T) {
+	defer func(saved map[string][]*ContainerImage) { registry = saved }(registry)
+	registry = NewRegistry(map[string]*ContainerImage{
+		"py": {Name: "Python", Extension: "py", Image: "dexec/lang-python", Version: "1.0.2"},
+		"c":  {Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2"},
+		"ts": {Name: "TypeScript", Extension: "ts", Image: "denoland/deno", Version: "1.46.3", Platform: "linux/amd64", Command: "deno run {source} {args}"},
+	}, nil)
+
+	var filters string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filters = r.URL.Query().Get("filters")
+		w.Write([]byte(`[
+			{"Id":"sha256:python","RepoTags":["corp/python:3.12"],"Labels":{"io.dexec.language":"Python","io.dexec.extensions":"py,pyw"}},
+			{"Id":"sha256:zig","RepoTags":["corp/zig:0.7"],"Labels":{"io.dexec.language":"Zig","io.dexec.extensions":"zig"}},
+			{"Id":"sha256:julia","RepoTags":["corp/julia:1.5"],"Labels":{"io.dexec.language":"Julia"}},
+			{"Id":"sha256:typescript","RepoTags":["corp/typescript:5.5"],"Labels":{"io.dexec.language":"TypeScript"}}
+		]`))
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notices bytes.Buffer
+	if err := LoadLabelledImages(client, &notices); err != nil {
+		t.Fatalf("LoadLabelledImages() unexpected error %v", err)
+	}
+	if !strings.Contains(filters, labelLanguage) {
+		t.Errorf("LoadLabelledImages() listed images with filters %q", filters)
+	}
+
+	want := map[string][]*ContainerImage{
+		"c":   {{Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2"}},
+		"py":  {{Name: "Python", Extension: "py", Image: "corp/python", Version: "3.12"}},
+		"pyw": {{Name: "Python", Extension: "pyw", Image: "corp/python", Version: "3.12"}},
+		"zig": {{Name: "Zig", Extension: "zig", Image: "corp/zig", Version: "0.7"}},
+		"ts":  {{Name: "TypeScript", Extension: "ts", Image: "corp/typescript", Version: "5.5"}},
+	}
+	if !reflect.DeepEqual(registry, want) {
+		t.Errorf("LoadLabelledImages() registry %v != %v", registry, want)
+	}
+	if !strings.Contains(notices.String(), "new language Julia has no io.dexec.extensions label") {
+		t.Errorf("LoadLabelledImages() notices %q", notices.String())
+	}
+
+	if err := LoadLabelledImages(client, ioutil.Discard); err != nil {
+		t.Errorf("LoadLabelledImages() a second time unexpected error %v", err)
+	}
+}
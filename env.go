@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// forwardedEnv lists the host variables that are passed to the container
+// unless they are set otherwise, so that text and times are shown in the
+// same way as on the host.
+var forwardedEnv = []string{"TZ", "LANG"}
+
+var envNamePattern = regexp.MustCompile(`^[^=\s]+$`)
+
+// ParseEnvFile takes the name and content of an environment file and returns
+// the variables it sets. As for docker run --env-file each line is either
+// KEY=VALUE or KEY on its own, to pass the variable through from the host,
+// and blank lines and lines starting with # are ignored. Values are taken as
+// they are, without removing quotes.
+func ParseEnvFile(filename string, content []byte) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimLeft(strings.TrimRight(scanner.Text(), "\r"), " \t")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := checkEnvVariable(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, line, err)
+		}
+		env = append(env, text)
+	}
+	return env, scanner.Err()
+}
+
+// checkEnvVariable checks that a variable is given as KEY=VALUE or KEY.
+func checkEnvVariable(variable string) error {
+	if name := strings.SplitN(variable, "=", 2)[0]; !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid environment variable %q, expected KEY=VALUE or KEY", variable)
+	}
+	return nil
+}
+
+// MaskEnv hides the value of a KEY=VALUE variable so that it can be
+// displayed, keeping only the key. A KEY passed through from the host has no
+// value to hide and is returned as it is.
+func MaskEnv(variable string) string {
+	if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+		return parts[0] + "=********"
+	}
+	return variable
+}
+
+// MergeEnv combines lists of variables in order of increasing precedence.
+// Each variable appears once, in the position it first appeared in, with the
+// value from the last list that sets it.
+func MergeEnv(lists ...[]string) []string {
+	var merged []string
+	index := map[string]int{}
+	for _, list := range lists {
+		for _, variable := range list {
+			name := strings.SplitN(variable, "=", 2)[0]
+			if i, ok := index[name]; ok {
+				merged[i] = variable
+			} else {
+				index[name] = len(merged)
+				merged = append(merged, variable)
+			}
+		}
+	}
+	return merged
+}
+
+// resolveEnv returns the variables with those given as KEY on their own
+// replaced by their value on the host, as given by environ, or left out if
+// they aren't set there.
+func resolveEnv(variables []string, environ []string) []string {
+	host := map[string]string{}
+	for _, variable := range environ {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 {
+			host[parts[0]] = parts[1]
+		}
+	}
+
+	var resolved []string
+	for _, variable := range variables {
+		if strings.Contains(variable, "=") {
+			resolved = append(resolved, variable)
+		} else if value, ok := host[variable]; ok {
+			resolved = append(resolved, variable+"="+value)
+		}
+	}
+	return resolved
+}
+
+// ContainerEnv returns the environment of the container that runs the
+// sources. In order of increasing precedence it is made up of TZ and LANG
+// from the host, the base variables, such as the proxy settings, the
+// language's variables from the registry, the files given with --env-file,
+// relative to the source directory, and the variables given with -E.
+func ContainerEnv(options map[OptionType][]string, image *ContainerImage, base []string, environ []string) ([]string, error) {
+	lists := [][]string{resolveEnv(forwardedEnv, environ), base, resolveEnv(image.Env, environ)}
+
+	for _, filename := range options[EnvFile] {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(RetrievePath(options[TargetDir]), filename)
+		}
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		env, err := ParseEnvFile(filename, content)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, resolveEnv(env, environ))
+	}
+
+	for _, variable := range options[Env] {
+		if err := checkEnvVariable(variable); err != nil {
+			return nil, err
+		}
+	}
+	lists = append(lists, resolveEnv(options[Env], environ))
+	return MergeEnv(lists...), nil
+}
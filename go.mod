@@ -4,7 +4,7 @@ go 1.12
 
 require (
 	github.com/docker/docker v0.7.3-0.20190309235953-33c3200e0d16
-	github.com/docker/go-units v0.4.0 // indirect
+	github.com/docker/go-units v0.4.0
 	github.com/fsouza/go-dockerclient v1.6.4
 	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/kisielk/errcheck v1.2.0 // indirect
@@ -16,4 +16,5 @@ require (
 	golang.org/x/sys v0.0.0-20190424175732-18eb32c0e2f0 // indirect
 	golang.org/x/text v0.3.1 // indirect
 	golang.org/x/tools v0.0.0-20190424220101-1e8e1cfdf96b // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
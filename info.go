@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// toolchainTimeout is how long the container reporting the toolchain version
+// is allowed to run before it is killed.
+var toolchainTimeout = 30 * time.Second
+
+// toolchainCommands maps the repository names of the dexec images and of the
+// official images used by recipes to the command that prints the version of
+// the compiler or interpreter they use.
+var toolchainCommands = map[string][]string{
+	"bash":            {"bash", "--version"},
+	"dart":            {"dart", "--version"},
+	"deno":            {"deno", "--version"},
+	"eclipse-temurin": {"java", "-version"},
+	"elixir":          {"elixir", "--version"},
+	"gcc":             {"gcc", "--version"},
+	"golang":          {"go", "version"},
+	"haskell":         {"ghc", "--version"},
+	"julia":           {"julia", "--version"},
+	"node":            {"node", "--version"},
+	"octave":          {"octave-cli", "--version"},
+	"perl":            {"perl", "--version"},
+	"php":             {"php", "--version"},
+	"python":          {"python", "--version"},
+	"r-base":          {"R", "--version"},
+	"ruby":            {"ruby", "--version"},
+	"rust":            {"rustc", "--version"},
+	"swift":           {"swift", "--version"},
+	"swipl":           {"swipl", "--version"},
+
+	"lang-bash":    {"bash", "--version"},
+	"lang-c":       {"gcc", "--version"},
+	"lang-coffee":  {"coffee", "--version"},
+	"lang-cpp":     {"g++", "--version"},
+	"lang-csharp":  {"mcs", "--version"},
+	"lang-d":       {"dmd", "--version"},
+	"lang-go":      {"go", "version"},
+	"lang-groovy":  {"groovy", "--version"},
+	"lang-haskell": {"ghc", "--version"},
+	"lang-java":    {"javac", "-version"},
+	"lang-lua":     {"lua", "-v"},
+	"lang-nim":     {"nim", "--version"},
+	"lang-node":    {"node", "--version"},
+	"lang-objc":    {"gcc", "--version"},
+	"lang-ocaml":   {"ocaml", "-version"},
+	"lang-perl":    {"perl", "--version"},
+	"lang-perl6":   {"perl6", "--version"},
+	"lang-php":     {"php", "--version"},
+	"lang-python":  {"python", "--version"},
+	"lang-r":       {"R", "--version"},
+	"lang-racket":  {"racket", "--version"},
+	"lang-ruby":    {"ruby", "--version"},
+	"lang-rust":    {"rustc", "--version"},
+	"lang-scala":   {"scala", "-version"},
+}
+
+// ImageInfo describes the local image for a language.
+type ImageInfo struct {
+	Name       string            `json:"name"`
+	Image      string            `json:"image"`
+	ID         string            `json:"id"`
+	Digests    []string          `json:"digests"`
+	Platform   string            `json:"platform"`
+	Size       int64             `json:"size"`
+	Created    time.Time         `json:"created"`
+	Labels     map[string]string `json:"labels"`
+	Entrypoint []string          `json:"entrypoint"`
+	Toolchain  string            `json:"toolchain,omitempty"`
+}
+
+// LookupImageForInfo returns the image for a language given by extension
+// or, failing that, by name.
+func LookupImageForInfo(language string) (*ContainerImage, error) {
+	if image, err := LookupImageByExtension(language); err == nil {
+		return image, nil
+	}
+	return LookupImageByLanguage(language)
+}
+
+// InspectImageInfo returns the details of the local image for a language,
+// which may have been pulled through one of the mirrors. It returns an error
+// if the image isn't present.
+func InspectImageInfo(image *ContainerImage, mirrors []RegistryMirror, client *docker.Client) (*ImageInfo, error) {
+	local, inspected, err := FindLocalImage(image, mirrors, client)
+	if err != nil {
+		return nil, err
+	} else if local == nil {
+		return nil, fmt.Errorf("image %s for %s is not present locally, pull it with 'dexec pull %s'",
+			image.Reference(), image.Name, image.Extension)
+	}
+
+	info := &ImageInfo{
+		Name:     image.Name,
+		Image:    local.Reference(),
+		ID:       inspected.ID,
+		Digests:  inspected.RepoDigests,
+		Platform: ImagePlatform(inspected),
+		Size:     inspected.Size,
+		Created:  inspected.Created,
+	}
+	if inspected.Config != nil {
+		info.Labels = inspected.Config.Labels
+		info.Entrypoint = inspected.Config.Entrypoint
+	}
+	return info, nil
+}
+
+// ToolchainVersion runs a short-lived container from the image to report the
+// version of its compiler or interpreter, returning the first line printed.
+// It returns an error if the version command for the image isn't known.
+func ToolchainVersion(reference string, client *docker.Client) (string, error) {
+	repository := repositoryName(reference)
+	command, ok := toolchainCommands[path.Base(repository)]
+	if !ok {
+		return "", fmt.Errorf("no toolchain version command known for %s", repository)
+	}
+
+	container, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      reference,
+			Entrypoint: command[:1],
+			Cmd:        command[1:],
+			Tty:        true,
+		},
+		HostConfig: &docker.HostConfig{NetworkMode: "none"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+
+	if err := client.StartContainer(container.ID, nil); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.WaitContainer(container.ID)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return "", err
+		}
+	case <-time.After(toolchainTimeout):
+		client.KillContainer(docker.KillContainerOptions{ID: container.ID})
+		return "", fmt.Errorf("%s timed out after %s", strings.Join(command, " "), toolchainTimeout)
+	}
+
+	var output bytes.Buffer
+	if err := client.Logs(docker.LogsOptions{
+		Container:    container.ID,
+		OutputStream: &output,
+		Stdout:       true,
+		Stderr:       true,
+		RawTerminal:  true,
+	}); err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(output.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("%s printed nothing", strings.Join(command, " "))
+}
+
+// DisplayImageInfo writes the details of an image either as a list of fields
+// or as JSON.
+func DisplayImageInfo(w io.Writer, info *ImageInfo, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "Name:\t%s\n", info.Name)
+	fmt.Fprintf(table, "Image:\t%s\n", info.Image)
+	fmt.Fprintf(table, "ID:\t%s\n", info.ID)
+	fmt.Fprintf(table, "Digests:\t%s\n", displayList(info.Digests))
+	fmt.Fprintf(table, "Platform:\t%s\n", info.Platform)
+	fmt.Fprintf(table, "Size:\t%s\n", units.HumanSize(float64(info.Size)))
+	fmt.Fprintf(table, "Created:\t%s\n", info.Created.Format(time.RFC3339))
+	fmt.Fprintf(table, "Entrypoint:\t%s\n", displayList([]string{strings.Join(info.Entrypoint, " ")}))
+
+	var labels []string
+	for key, value := range info.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(labels)
+	fmt.Fprintf(table, "Labels:\t%s\n", displayList(labels))
+	if info.Toolchain != "" {
+		fmt.Fprintf(table, "Toolchain:\t%s\n", info.Toolchain)
+	}
+	return table.Flush()
+}
+
+// displayList joins the values with a tab-aligned line for each, or returns
+// "-" if there are none.
+func displayList(values []string) string {
+	var nonEmpty []string
+	for _, value := range values {
+		if value != "" {
+			nonEmpty = append(nonEmpty, value)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return "-"
+	}
+	return strings.Join(nonEmpty, "\n\t")
+}
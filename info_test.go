@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestLookupImageForInfo(t *testing.T) {
+	cases := []struct {
+		language  string
+		wantName  string
+		wantError bool
+	}{
+		{"cpp", "C++", false},
+		{"h", "C", false},
+		{"python", "Python", false},
+		{"Go", "Go", false},
+		{"cobol", "", true},
+	}
+	for _, c := range cases {
+		image, err := LookupImageForInfo(c.language)
+		if (err != nil) != c.wantError {
+			t.Errorf("LookupImageForInfo(%q) unexpected error %v", c.language, err)
+		} else if err == nil && image.Name != c.wantName {
+			t.Errorf("LookupImageForInfo(%q) %q != %q", c.language, image.Name, c.wantName)
+		}
+	}
+}
+
+func TestInspectImageInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/images/dexec/lang-cpp:1.0.2/json") {
+			http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"Id":"` + testImageID + `","RepoDigests":["dexec/lang-cpp@` + testDigest + `"],` +
+			`"Os":"linux","Architecture":"amd64","Size":1000,"Created":"2016-04-23T10:00:00Z",` +
+			`"Config":{"Entrypoint":["dexec-cpp"],"Labels":{"maintainer":"dexec"}}}`))
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := &ContainerImage{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2"}
+	got, err := InspectImageInfo(image, nil, client)
+	if err != nil {
+		t.Fatalf("InspectImageInfo() unexpected error %v", err)
+	}
+	want := &ImageInfo{
+		Name:       "C++",
+		Image:      "dexec/lang-cpp:1.0.2",
+		ID:         testImageID,
+		Digests:    []string{"dexec/lang-cpp@" + testDigest},
+		Platform:   "linux/amd64",
+		Size:       1000,
+		Created:    time.Date(2016, 4, 23, 10, 0, 0, 0, time.UTC),
+		Labels:     map[string]string{"maintainer": "dexec"},
+		Entrypoint: []string{"dexec-cpp"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InspectImageInfo() %+v != %+v", got, want)
+	}
+
+	missing := &ContainerImage{Name: "Go", Extension: "go", Image: "dexec/lang-go", Version: "1.0.1"}
+	if _, err := InspectImageInfo(missing, nil, client); err == nil || !strings.Contains(err.Error(), "dexec pull go") {
+		t.Errorf("InspectImageInfo() for a missing image unexpected error %v", err)
+	}
+}
+
+func TestToolchainVersion(t *testing.T) {
+	var created docker.Config
+	var removed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/create"):
+			json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"Id":"toolchain"}`))
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/wait"):
+			w.Write([]byte(`{"StatusCode":0}`))
+		case strings.HasSuffix(r.URL.Path, "/logs"):
+			w.Write([]byte("\r\ng++ (GCC) 5.3.0\r\nCopyright (C) 2015 Free Software Foundation, Inc.\r\n"))
+		case r.Method == http.MethodDelete:
+			removed = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ToolchainVersion("registry.corp.local/dexec/lang-cpp:1.0.2", client)
+	if err != nil || got != "g++ (GCC) 5.3.0" {
+		t.Errorf("ToolchainVersion() %q, %v", got, err)
+	}
+	if created.Image != "registry.corp.local/dexec/lang-cpp:1.0.2" || !reflect.DeepEqual(created.Entrypoint, []string{"g++"}) {
+		t.Errorf("ToolchainVersion() created container %+v", created)
+	}
+	if !removed {
+		t.Errorf("ToolchainVersion() did not remove its container")
+	}
+
+	if _, err := ToolchainVersion("example/mercury:22", client); err == nil {
+		t.Errorf("ToolchainVersion() expected error for an unknown image")
+	}
+}
+
+func TestDisplayImageInfo(t *testing.T) {
+	info := &ImageInfo{
+		Name:       "C++",
+		Image:      "dexec/lang-cpp:1.0.2",
+		ID:         testImageID,
+		Platform:   "linux/amd64",
+		Size:       1000,
+		Created:    time.Date(2016, 4, 23, 10, 0, 0, 0, time.UTC),
+		Labels:     map[string]string{"b": "2", "a": "1"},
+		Entrypoint: []string{"dexec-cpp"},
+		Toolchain:  "g++ (GCC) 5.3.0",
+	}
+	var out bytes.Buffer
+	if err := DisplayImageInfo(&out, info, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "Name:        C++\n" +
+		"Image:       dexec/lang-cpp:1.0.2\n" +
+		"ID:          " + testImageID + "\n" +
+		"Digests:     -\n" +
+		"Platform:    linux/amd64\n" +
+		"Size:        1kB\n" +
+		"Created:     2016-04-23T10:00:00Z\n" +
+		"Entrypoint:  dexec-cpp\n" +
+		"Labels:      a=1\n" +
+		"             b=2\n" +
+		"Toolchain:   g++ (GCC) 5.3.0\n"
+	if out.String() != want {
+		t.Errorf("DisplayImageInfo() %q != %q", out.String(), want)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	statusPresent = "present"
+	statusMissing = "missing"
+	statusUnknown = "unknown"
+)
+
+// LanguageStatus describes a language in the registry along with whether its
+// image is present in the local Docker repository.
+type LanguageStatus struct {
+	Name       string     `json:"name"`
+	Extensions []string   `json:"extensions"`
+	Image      string     `json:"image"`
+	Version    string     `json:"version"`
+	Digest     string     `json:"digest,omitempty"`
+	Platform   string     `json:"platform,omitempty"`
+	Status     string     `json:"status"`
+	Size       int64      `json:"size,omitempty"`
+	Created    *time.Time `json:"created,omitempty"`
+}
+
+// RegistryLanguages groups the extensions in the registry by language and
+// image, returning one entry per language sorted by name. The local status
+// of each image is not known at this point.
+func RegistryLanguages() []LanguageStatus {
+	var languages []LanguageStatus
+	index := map[string]int{}
+
+	for _, extension := range registryExtensions() {
+		for _, image := range registry[extension] {
+			key := fmt.Sprintf("%s\x00%s", image.Name, image.Reference())
+			if i, ok := index[key]; ok {
+				languages[i].Extensions = append(languages[i].Extensions, extension)
+				continue
+			}
+			index[key] = len(languages)
+			languages = append(languages, LanguageStatus{
+				Name:       image.Name,
+				Extensions: []string{extension},
+				Image:      image.Image,
+				Version:    image.Version,
+				Digest:     image.Digest,
+				Platform:   image.Platform,
+				Status:     statusUnknown,
+			})
+		}
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return strings.ToLower(languages[i].Name) < strings.ToLower(languages[j].Name)
+	})
+	return languages
+}
+
+// RegistryImages returns the image for each language in the registry, sorted
+// by language name, with images shared by several languages only included
+// once.
+func RegistryImages() []*ContainerImage {
+	var images []*ContainerImage
+	seen := map[string]bool{}
+	for _, language := range RegistryLanguages() {
+		image := &ContainerImage{
+			Name:      language.Name,
+			Extension: language.Extensions[0],
+			Image:     language.Image,
+			Version:   language.Version,
+			Digest:    language.Digest,
+			Platform:  language.Platform,
+		}
+		if !seen[image.Reference()] {
+			images = append(images, image)
+			seen[image.Reference()] = true
+		}
+	}
+	return images
+}
+
+// InspectLanguages fills in the local status, size and creation date of the
+// image for each language. An image pulled through one of the mirrors counts
+// as present.
+func InspectLanguages(languages []LanguageStatus, mirrors []RegistryMirror, client *docker.Client) error {
+	for i := range languages {
+		reference := &ContainerImage{Image: languages[i].Image, Version: languages[i].Version, Digest: languages[i].Digest}
+		_, image, err := FindLocalImage(reference, mirrors, client)
+		if err != nil {
+			return err
+		} else if image == nil {
+			languages[i].Status = statusMissing
+			continue
+		}
+		created := image.Created
+		languages[i].Status = statusPresent
+		languages[i].Size = image.Size
+		languages[i].Created = &created
+	}
+	return nil
+}
+
+// DisplayLanguages writes the languages either as a table or as JSON.
+func DisplayLanguages(w io.Writer, languages []LanguageStatus, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(languages)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tEXTENSIONS\tIMAGE\tVERSION\tLOCAL\tSIZE\tCREATED")
+	for _, language := range languages {
+		version, size, created := language.Version, "-", "-"
+		if language.Digest != "" {
+			version = fmt.Sprintf(dexecDigestTemplate, version, ShortDigest(language.Digest))
+		}
+		if language.Status == statusPresent {
+			size = units.HumanSize(float64(language.Size))
+			created = language.Created.Format("2006-01-02")
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			language.Name,
+			strings.Join(language.Extensions, ", "),
+			language.Image,
+			version,
+			language.Status,
+			size,
+			created)
+	}
+	return table.Flush()
+}
+
+// ShortDigest abbreviates a digest to its algorithm and first 12 hex digits
+// for display.
+func ShortDigest(digest string) string {
+	if parts := strings.SplitN(digest, ":", 2); len(parts) == 2 && len(parts[1]) > 12 {
+		return parts[0] + ":" + parts[1][:12]
+	}
+	return digest
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistryLanguages(t *testing.T) {
+	defer func(saved map[string][]*ContainerImage) { registry = saved }(registry)
+	registry = map[string][]*ContainerImage{
+		"cpp": {{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2"}},
+		"h":   {{Name: "C", Extension: "h", Image: "dexec/lang-c", Version: "1.0.2"}, {Name: "C++", Extension: "h", Image: "dexec/lang-cpp", Version: "1.0.2"}},
+		"c":   {{Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2"}},
+	}
+	want := []LanguageStatus{
+		{Name: "C", Extensions: []string{"c", "h"}, Image: "dexec/lang-c", Version: "1.0.2", Status: "unknown"},
+		{Name: "C++", Extensions: []string{"cpp", "h"}, Image: "dexec/lang-cpp", Version: "1.0.2", Status: "unknown"},
+	}
+	if got := RegistryLanguages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RegistryLanguages %v != %v", got, want)
+	}
+}
+
+func TestDisplayLanguages(t *testing.T) {
+	created := time.Date(2016, 4, 23, 0, 0, 0, 0, time.UTC)
+	languages := []LanguageStatus{
+		{Name: "C", Extensions: []string{"c", "h"}, Image: "dexec/lang-c", Version: "1.0.2", Status: "present", Size: 123000000, Created: &created},
+		{Name: "Go", Extensions: []string{"go"}, Image: "dexec/lang-go", Version: "1.0.1", Status: "missing"},
+	}
+
+	cases := []struct {
+		asJSON bool
+		want   string
+	}{
+		{
+			false,
+			"NAME  EXTENSIONS  IMAGE          VERSION  LOCAL    SIZE   CREATED\n" +
+				"C     c, h        dexec/lang-c   1.0.2    present  123MB  2016-04-23\n" +
+				"Go    go          dexec/lang-go  1.0.1    missing  -      -\n",
+		},
+		{
+			true,
+			`[
+  {
+    "name": "C",
+    "extensions": [
+      "c",
+      "h"
+    ],
+    "image": "dexec/lang-c",
+    "version": "1.0.2",
+    "status": "present",
+    "size": 123000000,
+    "created": "2016-04-23T00:00:00Z"
+  },
+  {
+    "name": "Go",
+    "extensions": [
+      "go"
+    ],
+    "image": "dexec/lang-go",
+    "version": "1.0.1",
+    "status": "missing"
+  }
+]
+`,
+		},
+	}
+	for _, c := range cases {
+		var out bytes.Buffer
+		if err := DisplayLanguages(&out, languages, c.asJSON); err != nil {
+			t.Errorf("DisplayLanguages unexpected error %q", err)
+		} else if out.String() != c.want {
+			t.Errorf("DisplayLanguages(%v) %q != %q", c.asJSON, out.String(), c.want)
+		}
+	}
+}
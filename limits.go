@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// The exit codes of a program killed by the signals sent when it exceeds its
+// CPU time or file size ulimit, SIGXCPU and SIGXFSZ.
+const (
+	cpuLimitStatusCode      = 128 + 24
+	fileSizeLimitStatusCode = 128 + 25
+)
+
+// ResourceLimits holds the limits placed on the container that runs the
+// sources. A zero value means that there is no limit. MemorySwap is the
+// limit on memory and swap together, or -1 for unlimited swap.
+type ResourceLimits struct {
+	Memory      int64
+	MemorySwap  int64
+	NanoCPUs    int64
+	PidsLimit   int64
+	Ulimits     []docker.ULimit
+	StorageSize string
+}
+
+// ResourceLimitsFromOptions returns the resource limits given with --memory,
+// --memory-swap, --cpus, --pids-limit, --ulimit and --storage-size. Sizes
+// are given as a number with an optional unit, e.g. 512m or 2g, and ulimits
+// as <name>=<soft>[:<hard>] as for docker run.
+func ResourceLimitsFromOptions(options map[OptionType][]string) (ResourceLimits, error) {
+	var limits ResourceLimits
+	var err error
+
+	if values := options[Memory]; len(values) > 0 {
+		if limits.Memory, err = units.RAMInBytes(values[0]); err != nil || limits.Memory <= 0 {
+			return ResourceLimits{}, fmt.Errorf("invalid memory limit %q, expected a size e.g. 512m", values[0])
+		}
+	}
+
+	if values := options[MemorySwap]; len(values) > 0 {
+		if limits.Memory == 0 {
+			return ResourceLimits{}, fmt.Errorf("--memory-swap requires --memory")
+		}
+		if values[0] == "-1" {
+			limits.MemorySwap = -1
+		} else if limits.MemorySwap, err = units.RAMInBytes(values[0]); err != nil || limits.MemorySwap < limits.Memory {
+			return ResourceLimits{}, fmt.Errorf("invalid memory and swap limit %q, expected -1 or a size no smaller than --memory", values[0])
+		}
+	}
+
+	if values := options[CPUs]; len(values) > 0 {
+		cpus, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || cpus <= 0 {
+			return ResourceLimits{}, fmt.Errorf("invalid number of CPUs %q, expected a number e.g. 1.5", values[0])
+		}
+		limits.NanoCPUs = int64(cpus * 1e9)
+	}
+
+	if values := options[PidsLimit]; len(values) > 0 {
+		if limits.PidsLimit, err = strconv.ParseInt(values[0], 10, 64); err != nil || limits.PidsLimit <= 0 {
+			return ResourceLimits{}, fmt.Errorf("invalid process limit %q, expected a positive number", values[0])
+		}
+	}
+
+	index := map[string]int{}
+	for _, value := range options[Ulimit] {
+		ulimit, err := units.ParseUlimit(value)
+		if err != nil {
+			return ResourceLimits{}, fmt.Errorf("invalid ulimit %q, expected <name>=<soft>[:<hard>] e.g. nofile=1024:2048", value)
+		}
+		parsed := docker.ULimit{Name: ulimit.Name, Soft: ulimit.Soft, Hard: ulimit.Hard}
+		if i, ok := index[ulimit.Name]; ok {
+			limits.Ulimits[i] = parsed
+		} else {
+			index[ulimit.Name] = len(limits.Ulimits)
+			limits.Ulimits = append(limits.Ulimits, parsed)
+		}
+	}
+
+	if values := options[StorageSize]; len(values) > 0 {
+		if size, err := units.RAMInBytes(values[0]); err != nil || size <= 0 {
+			return ResourceLimits{}, fmt.Errorf("invalid storage size %q, expected a size e.g. 1g", values[0])
+		}
+		limits.StorageSize = values[0]
+	}
+	return limits, nil
+}
+
+// Apply sets the limits on the host configuration of a container.
+func (limits ResourceLimits) Apply(hostConfig *docker.HostConfig) {
+	hostConfig.Memory = limits.Memory
+	hostConfig.MemorySwap = limits.MemorySwap
+	hostConfig.NanoCPUs = limits.NanoCPUs
+	if limits.PidsLimit > 0 {
+		pidsLimit := limits.PidsLimit
+		hostConfig.PidsLimit = &pidsLimit
+	}
+	hostConfig.Ulimits = limits.Ulimits
+	if limits.StorageSize != "" {
+		hostConfig.StorageOpt = map[string]string{"size": limits.StorageSize}
+	}
+}
+
+// ExplainExit returns why the program in a container with these limits was
+// killed if it was because of one of them, or the empty string otherwise.
+// Only the memory limit and the CPU time and file size ulimits kill the
+// program; it is up to the program to report the failures caused by the
+// other limits.
+func (limits ResourceLimits) ExplainExit(state docker.State) string {
+	if state.OOMKilled {
+		if limits.Memory > 0 {
+			return fmt.Sprintf("the program was killed for using more than its memory limit of %s (--memory)", units.BytesSize(float64(limits.Memory)))
+		}
+		return "the program was killed because the Docker host ran out of memory"
+	}
+
+	switch state.ExitCode {
+	case cpuLimitStatusCode:
+		if ulimit := limits.ulimit("cpu"); ulimit != nil {
+			return fmt.Sprintf("the program was killed for using more than its CPU time limit of %d seconds (--ulimit cpu)", ulimit.Soft)
+		}
+	case fileSizeLimitStatusCode:
+		if ulimit := limits.ulimit("fsize"); ulimit != nil {
+			return fmt.Sprintf("the program was killed for writing a file larger than its limit of %s (--ulimit fsize)", units.BytesSize(float64(ulimit.Soft)))
+		}
+	}
+	return ""
+}
+
+func (limits ResourceLimits) ulimit(name string) *docker.ULimit {
+	for i, ulimit := range limits.Ulimits {
+		if ulimit.Name == name {
+			return &limits.Ulimits[i]
+		}
+	}
+	return nil
+}
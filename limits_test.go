@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestResourceLimitsFromOptions(t *testing.T) {
+	options := map[OptionType][]string{
+		Memory:      {"512m"},
+		MemorySwap:  {"1g"},
+		CPUs:        {"1.5"},
+		PidsLimit:   {"64"},
+		Ulimit:      {"nofile=1024:2048", "cpu=10", "nofile=512"},
+		StorageSize: {"2g"},
+	}
+	want := ResourceLimits{
+		Memory:     512 * 1024 * 1024,
+		MemorySwap: 1024 * 1024 * 1024,
+		NanoCPUs:   1500000000,
+		PidsLimit:  64,
+		Ulimits: []docker.ULimit{
+			{Name: "nofile", Soft: 512, Hard: 512},
+			{Name: "cpu", Soft: 10, Hard: 10},
+		},
+		StorageSize: "2g",
+	}
+	got, err := ResourceLimitsFromOptions(options)
+	if err != nil {
+		t.Fatalf("ResourceLimitsFromOptions() unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResourceLimitsFromOptions() %+v != %+v", got, want)
+	}
+
+	if got, err := ResourceLimitsFromOptions(map[OptionType][]string{Memory: {"256m"}, MemorySwap: {"-1"}}); err != nil || got.MemorySwap != -1 {
+		t.Errorf("ResourceLimitsFromOptions() unlimited swap %+v, %v", got, err)
+	}
+	if got, err := ResourceLimitsFromOptions(map[OptionType][]string{}); err != nil || !reflect.DeepEqual(got, ResourceLimits{}) {
+		t.Errorf("ResourceLimitsFromOptions() without limits %+v, %v", got, err)
+	}
+}
+
+func TestResourceLimitsFromOptionsErrors(t *testing.T) {
+	cases := []struct {
+		options map[OptionType][]string
+		want    string
+	}{
+		{map[OptionType][]string{Memory: {"lots"}}, `invalid memory limit "lots", expected a size e.g. 512m`},
+		{map[OptionType][]string{MemorySwap: {"1g"}}, "--memory-swap requires --memory"},
+		{map[OptionType][]string{Memory: {"1g"}, MemorySwap: {"512m"}}, `invalid memory and swap limit "512m", expected -1 or a size no smaller than --memory`},
+		{map[OptionType][]string{CPUs: {"0"}}, `invalid number of CPUs "0", expected a number e.g. 1.5`},
+		{map[OptionType][]string{PidsLimit: {"-1"}}, `invalid process limit "-1", expected a positive number`},
+		{map[OptionType][]string{Ulimit: {"files=10"}}, `invalid ulimit "files=10", expected <name>=<soft>[:<hard>] e.g. nofile=1024:2048`},
+		{map[OptionType][]string{StorageSize: {"big"}}, `invalid storage size "big", expected a size e.g. 1g`},
+	}
+	for _, c := range cases {
+		if _, err := ResourceLimitsFromOptions(c.options); err == nil || err.Error() != c.want {
+			t.Errorf("ResourceLimitsFromOptions(%v) %v != %q", c.options, err, c.want)
+		}
+	}
+}
+
+func TestResourceLimitsApply(t *testing.T) {
+	limits := ResourceLimits{
+		Memory:      1024,
+		MemorySwap:  -1,
+		NanoCPUs:    500000000,
+		PidsLimit:   32,
+		Ulimits:     []docker.ULimit{{Name: "nproc", Soft: 10, Hard: 20}},
+		StorageSize: "1g",
+	}
+	hostConfig := &docker.HostConfig{Binds: []string{"/src:/tmp/dexec/build/src"}}
+	limits.Apply(hostConfig)
+
+	pidsLimit := int64(32)
+	want := &docker.HostConfig{
+		Binds:      []string{"/src:/tmp/dexec/build/src"},
+		Memory:     1024,
+		MemorySwap: -1,
+		NanoCPUs:   500000000,
+		PidsLimit:  &pidsLimit,
+		Ulimits:    []docker.ULimit{{Name: "nproc", Soft: 10, Hard: 20}},
+		StorageOpt: map[string]string{"size": "1g"},
+	}
+	if !reflect.DeepEqual(hostConfig, want) {
+		t.Errorf("Apply() %+v != %+v", hostConfig, want)
+	}
+
+	unlimited := &docker.HostConfig{}
+	ResourceLimits{}.Apply(unlimited)
+	if !reflect.DeepEqual(unlimited, &docker.HostConfig{}) {
+		t.Errorf("Apply() without limits %+v", unlimited)
+	}
+}
+
+func TestResourceLimitsExplainExit(t *testing.T) {
+	limits := ResourceLimits{
+		Memory:  256 * 1024 * 1024,
+		Ulimits: []docker.ULimit{{Name: "cpu", Soft: 5, Hard: 5}, {Name: "fsize", Soft: 1024, Hard: 1024}},
+	}
+	cases := []struct {
+		limits ResourceLimits
+		state  docker.State
+		want   string
+	}{
+		{limits, docker.State{OOMKilled: true, ExitCode: 137}, "the program was killed for using more than its memory limit of 256MiB (--memory)"},
+		{ResourceLimits{}, docker.State{OOMKilled: true, ExitCode: 137}, "the program was killed because the Docker host ran out of memory"},
+		{limits, docker.State{ExitCode: 152}, "the program was killed for using more than its CPU time limit of 5 seconds (--ulimit cpu)"},
+		{limits, docker.State{ExitCode: 153}, "the program was killed for writing a file larger than its limit of 1KiB (--ulimit fsize)"},
+		{ResourceLimits{}, docker.State{ExitCode: 152}, ""},
+		{limits, docker.State{ExitCode: 1}, ""},
+	}
+	for _, c := range cases {
+		if got := c.limits.ExplainExit(c.state); got != c.want {
+			t.Errorf("ExplainExit(%+v) %q != %q", c.state, got, c.want)
+		}
+	}
+}
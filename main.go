@@ -1,25 +1,31 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
-	"regexp"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 )
 
 const timeoutStatusCode = 124
+const digestMismatchStatusCode = 125
 
 // RunDexecContainer runs an anonymous Docker container with a Docker Exec
 // image, mounting the specified sources and includes and passing the
-// list of sources and arguments to the entrypoint.
+// list of sources and arguments to the entrypoint. If a command template is
+// given the container runs the expanded template instead, with any shebangs
+// removed from the sources beforehand.
 func RunDexecContainer(cliParser CLI) int {
 	options := cliParser.Options
-
 	shouldClean := len(options[CleanFlag]) > 0
 	updateImage := len(options[UpdateFlag]) > 0
 
@@ -29,75 +35,191 @@ func RunDexecContainer(cliParser CLI) int {
 		log.Fatal(err)
 	}
 
+	pullOptions, err := PullOptionsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limits, err := ResourceLimitsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	networkOptions, err := NetworkOptionsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	securityOptions, err := SecurityOptionsFromOptions(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if shouldClean {
-		images, err := client.ListImages(docker.ListImagesOptions{
-			All: true,
-		})
-		if err != nil {
-			log.Fatal(err)
+		if code := RunCleanCommand(cliParser, os.Stderr); code != 0 {
+			return code
 		}
-		for _, image := range images {
-			for _, tag := range image.RepoTags {
-				repoRegex := regexp.MustCompile("^dexec/lang-[^:\\s]+(:.+)?$")
-				if match := repoRegex.MatchString(tag); match {
-					if err := client.RemoveImage(image.ID); err != nil {
-						log.Fatalf("cannot remove image %s", image.ID)
-					}
-				}
-			}
+	}
+
+	readFromStdin := IsStdinPiped() || len(options[StdinFlag]) > 0
+
+	var input io.Reader = os.Stdin
+	var stdinContent []byte
+	if readFromStdin && len(options[Source]) == 0 {
+		if stdinContent, err = ioutil.ReadAll(os.Stdin); err != nil {
+			log.Fatal(err)
 		}
+		input = bytes.NewReader(stdinContent)
 	}
 
-	dexecImage, err := ImageFromOptions(options)
+	dexecImage, reasons, err := ImageFromOptions(options, cliParser.Origins[Language], stdinContent)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	dockerImage := fmt.Sprintf("%s:%s", dexecImage.Image, dexecImage.Version)
+	if len(options[ExplainFlag]) > 0 {
+		DisplayExplanation(dexecImage, reasons, cliParser.Origins[Language])
+	}
 
-	if err = FetchImage(
-		dexecImage.Image,
-		dexecImage.Version,
-		updateImage,
-		client); err != nil {
+	updatePolicy, err := ParseUpdatePolicy(strings.Join(options[UpdatePolicy], ""))
+	if err != nil {
 		log.Fatal(err)
 	}
 
+	var localImage *ContainerImage
+	if updateImage {
+		localImage, err = FetchImage(dexecImage, pullOptions, true, client)
+	} else if !updatePolicy.ChecksImage(dexecImage, pullOptions) {
+		localImage, err = FetchImage(dexecImage, pullOptions, false, client)
+	} else {
+		stateFilename := filepath.Join(StateDir(), updateStateFilename)
+		updateState, stateErr := LoadUpdateState(stateFilename)
+		if stateErr != nil {
+			log.Fatal(stateErr)
+		}
+		localImage, err = RefreshImage(dexecImage, pullOptions, updatePolicy, updateState, os.Stderr, time.Now(), client)
+		if stateErr = updateState.Save(stateFilename); stateErr != nil {
+			log.Printf("unable to save update check times: %s", stateErr)
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*DigestMismatchError); ok {
+			log.Print(err)
+			return digestMismatchStatusCode
+		}
+		log.Fatal(err)
+	}
+
+	if build := BuildFromOptions(options, dexecImage); build != nil {
+		if localImage, err = BuildImage(localImage, build, pullOptions, client); err != nil {
+			log.Fatal(err)
+		}
+	}
+	dockerImage := localImage.Reference()
+
+	// The client can't pass the platform when creating the container, so the
+	// local image is checked instead. FetchImage has already made sure that it
+	// was pulled for the platform asked for, if any.
+	inspected, err := client.InspectImage(dockerImage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	info, err := client.Info()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := CheckPlatform(dockerImage, inspected, pullOptions.platformFor(dexecImage), info, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+
+	path := RetrievePath(options[TargetDir])
+	command := CommandFromOptions(options, dexecImage)
+
 	var sourceBasenames []string
+	var binds []string
 	for _, source := range options[Source] {
-		basename, _ := ExtractBasenameAndPermission(source)
+		basename, _ := ExtractBasenameAndPermission(AddSourceExtension(source, dexecImage.Extension))
 		sourceBasenames = append(sourceBasenames, []string{basename}...)
+		if command == "" {
+			binds = append(binds, BuildSourceVolumeArg(path, source, dexecImage.Extension))
+		}
+	}
+	// Deferred calls are skipped when exiting with log.Fatal, so once there
+	// are copies of the sources to remove these are used in its place.
+	fatal, fatalf := log.Fatal, log.Fatalf
+	if command != "" {
+		sourceBinds, cleanup, err := StripSourceShebangs(path, options[Source], dexecImage.Extension)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cleanup()
+		fatal = func(v ...interface{}) {
+			cleanup()
+			log.Fatal(v...)
+		}
+		fatalf = func(format string, v ...interface{}) {
+			cleanup()
+			log.Fatalf(format, v...)
+		}
+		binds = sourceBinds
+		if stdinContent != nil {
+			stdinContent, _ = StripShebang(stdinContent)
+			input = bytes.NewReader(stdinContent)
+		}
 	}
+	binds = append(binds, BuildVolumeArgs(path, options[Include])...)
 
+	var entrypoint []string
+	var workingDir string
 	entrypointArgs := JoinStringSlices(
 		sourceBasenames,
 		AddPrefix(options[BuildArg], "-b"),
 		AddPrefix(options[Arg], "-a"),
 	)
+	if command != "" {
+		expanded, err := ExpandCommand(command, sourceBasenames, options[BuildArg], options[Arg])
+		if err != nil {
+			fatal(err)
+		}
+		entrypoint, entrypointArgs, workingDir = expanded[:1], expanded[1:], dexecPath
+	}
 
-	readFromStdin := false
+	env, err := ContainerEnv(options, dexecImage, networkOptions.ProxyEnv(os.Environ()), os.Environ())
+	if err != nil {
+		fatal(err)
+	}
 
-	if stat, _ := os.Stdin.Stat(); (stat.Mode() & os.ModeCharDevice) == 0 {
-		readFromStdin = true
-	} else {
+	newline := "\n"
+	if !readFromStdin {
 		fd := int(os.Stdin.Fd())
 		if terminal.IsTerminal(fd) {
+			newline = "\r\n"
 			oldState, err := terminal.MakeRaw(fd)
 			if err != nil {
-				log.Fatalf("could not make terminal raw: %s", err)
+				fatalf("could not make terminal raw: %s", err)
 			}
 			defer func() {
 				if err := terminal.Restore(fd, oldState); err != nil {
-					log.Fatalf("couldn't restore terminal: %s", err)
+					fatalf("couldn't restore terminal: %s", err)
 				}
 			}()
 		}
 	}
 
+	hostConfig := &docker.HostConfig{
+		Binds: binds,
+	}
+	limits.Apply(hostConfig)
+	networkOptions.Apply(hostConfig)
+	securityOptions.Apply(hostConfig)
+
 	container, err := client.CreateContainer(docker.CreateContainerOptions{
 		Config: &docker.Config{
 			Image:        dockerImage,
+			Entrypoint:   entrypoint,
 			Cmd:          entrypointArgs,
+			WorkingDir:   workingDir,
+			Env:          env,
 			StdinOnce:    true,
 			OpenStdin:    true,
 			AttachStdin:  true,
@@ -105,29 +227,25 @@ func RunDexecContainer(cliParser CLI) int {
 			AttachStdout: true,
 			Tty:          !readFromStdin,
 		},
-		HostConfig: &docker.HostConfig{
-			Binds: BuildVolumeArgs(
-				RetrievePath(options[TargetDir]),
-				append(options[Source], options[Include]...)),
-		},
+		HostConfig: hostConfig,
 	})
 
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	defer func() {
 		if err = client.RemoveContainer(docker.RemoveContainerOptions{
 			ID: container.ID,
 		}); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	}()
 
 	success := make(chan struct{})
 	waiter, err := client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
 		Container:    container.ID,
-		InputStream:  os.Stdin,
+		InputStream:  input,
 		OutputStream: os.Stdout,
 		ErrorStream:  os.Stderr,
 		Stream:       true,
@@ -139,13 +257,13 @@ func RunDexecContainer(cliParser CLI) int {
 		Success:      success,
 	})
 	if err != nil {
-		log.Fatalf("unable to send attach to container request: %s", err)
+		fatalf("unable to send attach to container request: %s", err)
 	}
 	<-success
 	close(success)
 
 	if err = client.StartContainer(container.ID, &docker.HostConfig{}); err != nil {
-		log.Fatalf("unable to start container: %s", err)
+		fatalf("unable to start container: %s", err)
 	}
 
 	if timeout, ok := options[Timeout]; ok && len(timeout) == 1 {
@@ -157,7 +275,7 @@ func RunDexecContainer(cliParser CLI) int {
 		done := make(chan ClientRunResult)
 		go func() {
 			if err := waiter.Wait(); err != nil {
-				log.Fatalf("unable to attach to container: %s", err)
+				fatalf("unable to attach to container: %s", err)
 			}
 
 			code, err := client.WaitContainer(container.ID)
@@ -176,50 +294,98 @@ func RunDexecContainer(cliParser CLI) int {
 		case <-timeout:
 			err := client.KillContainer(docker.KillContainerOptions{ID: container.ID})
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			return timeoutStatusCode
 		case result := <-done:
 			code := result.Code
 			err := result.Error
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
+			reportLimitExit(client, container.ID, limits, code, newline)
 			return code
 		}
 	} else {
 		if err := waiter.Wait(); err != nil {
-			log.Fatalf("unable to attach to container: %s", err)
+			fatalf("unable to attach to container: %s", err)
 		}
 
 		code, err := client.WaitContainer(container.ID)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
+		reportLimitExit(client, container.ID, limits, code, newline)
 		return code
 	}
 }
 
+// reportLimitExit tells the user if the program in a container that exited
+// with a non-zero code was killed because of one of its resource limits. The
+// terminal may still be in raw mode, so the line ending is given.
+func reportLimitExit(client *docker.Client, id string, limits ResourceLimits, code int, newline string) {
+	if code == 0 {
+		return
+	}
+	container, err := client.InspectContainer(id)
+	if err != nil {
+		return
+	}
+	if reason := limits.ExplainExit(container.State); reason != "" {
+		fmt.Fprintf(os.Stderr, "dexec: %s%s", reason, newline)
+	}
+}
+
 func validate(cliParser CLI) bool {
 	options := cliParser.Options
 
-	hasVersionFlag := len(options[VersionFlag]) == 1
 	hasSources := len(options[Source]) > 0
+	hasStdinSource := len(options[StdinFlag]) > 0 || IsStdinPiped() && chosenOnCommandLine(cliParser)
 	shouldClean := len(options[CleanFlag]) > 0
 
-	if hasSources || shouldClean {
+	if hasSources || hasStdinSource || shouldClean {
 		return true
 	}
 
-	if hasVersionFlag {
+	DisplayHelp(cliParser.Filename)
+	return false
+}
+
+// displayInfo shows the help or version if either was asked for and reports
+// whether it did. This is done before any configuration is loaded, so that a
+// broken project file doesn't stop them from being shown.
+func displayInfo(cliParser CLI) bool {
+	if len(cliParser.Options[HelpFlag]) > 0 {
+		DisplayHelp(cliParser.Filename)
+		return true
+	}
+	if len(cliParser.Command) == 0 && len(cliParser.Options[VersionFlag]) > 0 {
 		DisplayVersion(cliParser.Filename)
-		return false
+		return true
 	}
+	return false
+}
 
-	DisplayHelp(cliParser.Filename)
+// chosenOnCommandLine reports whether the language or image was given on the
+// command line, so that piped STDIN is taken to be the program rather than
+// the input to one. Values from configuration don't count, as they would make
+// a bare dexec run whatever it happens to be given on STDIN.
+func chosenOnCommandLine(cliParser CLI) bool {
+	for _, optionType := range []OptionType{Language, Extension, Image} {
+		if len(cliParser.Options[optionType]) > 0 && cliParser.Origins[optionType] == commandLineOrigin {
+			return true
+		}
+	}
 	return false
 }
 
+// needsRegistry reports whether images are looked up in the registry, so that
+// the local images labelled as dexec language images need to be found first.
+// This isn't the case when showing the configuration.
+func needsRegistry(cliParser CLI) bool {
+	return len(cliParser.Command) == 0 || cliParser.Command[0] != "config"
+}
+
 func validateDocker() error {
 	client, err := docker.NewClientFromEnv()
 	if err != nil {
@@ -241,6 +407,52 @@ func validateDocker() error {
 
 func main() {
 	cliParser := ParseOsArgs(os.Args)
+	if displayInfo(cliParser) {
+		return
+	}
+
+	// Directives are only read from source files, not from the arguments
+	// to commands such as pull or bundle.
+	sources := cliParser.Options[Source]
+	if len(cliParser.Command) > 0 && cliParser.Command[0] != "config" {
+		sources = nil
+	}
+
+	layers, err := LoadOptionLayers(cliParser.Options, sources)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if layers, err = ApplySandbox(layers); err != nil {
+		log.Fatal(err)
+	}
+	cliParser.Options, cliParser.Origins = MergeOptions(layers...)
+
+	if needsRegistry(cliParser) {
+		// Labelled images are optional, so if the Docker host can't be
+		// reached they are left out and the error is reported later on.
+		if client, err := docker.NewClientFromEnv(); err == nil {
+			LoadLabelledImages(client, os.Stderr)
+		}
+	}
+
+	if err := LoadUserRegistry(); err != nil {
+		log.Fatal(err)
+	}
+	if err := ApplyLanguageVersions(cliParser.Options[LanguageVersion]); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(cliParser.Command) == 0 && len(cliParser.Options[ListFlag]) > 0 {
+		cliParser.Command = []string{"languages"}
+	}
+
+	if len(cliParser.Command) == 0 && len(cliParser.Options[CleanFlag]) > 0 && len(cliParser.Options[Source]) == 0 {
+		cliParser.Command = []string{"clean"}
+	}
+
+	if len(cliParser.Command) > 0 {
+		os.Exit(RunCommand(cliParser))
+	}
 
 	if validate(cliParser) {
 		if err := validateDocker(); err != nil {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultMirrorSource is the image prefix that a mirror replaces when none is
+// given explicitly.
+const defaultMirrorSource = "dexec/"
+
+// RegistryMirror rewrites the names of images starting with Source so that
+// they are pulled from Prefix instead, e.g. dexec/lang-c becomes
+// registry.corp.local/dexec/lang-c for a Prefix of registry.corp.local/dexec/.
+type RegistryMirror struct {
+	Source string
+	Prefix string
+}
+
+// ParseMirror takes a mirror in the form PREFIX or SOURCE=PREFIX and returns
+// it as a RegistryMirror. If no source is given the mirror applies to dexec/ images.
+func ParseMirror(value string) (RegistryMirror, error) {
+	mirror := RegistryMirror{Source: defaultMirrorSource, Prefix: value}
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		mirror = RegistryMirror{Source: parts[0], Prefix: parts[1]}
+	}
+	if mirror.Source == "" || mirror.Prefix == "" || strings.ContainsAny(value, " \t@") {
+		return mirror, fmt.Errorf("invalid mirror %q, expected PREFIX or SOURCE=PREFIX", value)
+	}
+	if !strings.HasSuffix(mirror.Source, "/") {
+		mirror.Source += "/"
+	}
+	if !strings.HasSuffix(mirror.Prefix, "/") {
+		mirror.Prefix += "/"
+	}
+	return mirror, nil
+}
+
+// ParseMirrors parses each of the given mirrors in turn.
+func ParseMirrors(values []string) ([]RegistryMirror, error) {
+	var mirrors []RegistryMirror
+	for _, value := range values {
+		mirror, err := ParseMirror(value)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors, nil
+}
+
+// Rewrite returns the name of the image in the mirror and whether the mirror
+// applies to the image at all.
+func (mirror RegistryMirror) Rewrite(image string) (string, bool) {
+	if !strings.HasPrefix(image, mirror.Source) {
+		return image, false
+	}
+	return mirror.Prefix + strings.TrimPrefix(image, mirror.Source), true
+}
+
+// MirroredImages returns the images to try for an image, in order: the image
+// as named by each mirror that applies to it followed by the image itself.
+func MirroredImages(image *ContainerImage, mirrors []RegistryMirror) []*ContainerImage {
+	var images []*ContainerImage
+	seen := map[string]bool{image.Image: true}
+	for _, mirror := range mirrors {
+		if name, ok := mirror.Rewrite(image.Image); ok && !seen[name] {
+			mirrored := *image
+			mirrored.Image = name
+			images = append(images, &mirrored)
+			seen[name] = true
+		}
+	}
+	return append(images, image)
+}
+
+// CleanImagePattern returns a pattern that matches the repository tags of
+// dexec images, whether pulled directly or through one of the mirrors.
+func CleanImagePattern(mirrors []RegistryMirror) *regexp.Regexp {
+	prefixes := []string{regexp.QuoteMeta(defaultMirrorSource)}
+	for _, mirror := range mirrors {
+		if mirror.Source == defaultMirrorSource {
+			prefixes = append(prefixes, regexp.QuoteMeta(mirror.Prefix))
+		}
+	}
+	return regexp.MustCompile(fmt.Sprintf(`^(?:%s)lang-[^:\s]+(:.+)?$`, strings.Join(prefixes, "|")))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMirror(t *testing.T) {
+	cases := []struct {
+		value      string
+		wantMirror RegistryMirror
+		wantError  bool
+	}{
+		{"registry.corp.local/dexec/", RegistryMirror{"dexec/", "registry.corp.local/dexec/"}, false},
+		{"registry.corp.local:5000/dexec", RegistryMirror{"dexec/", "registry.corp.local:5000/dexec/"}, false},
+		{"example=registry.corp.local/example/", RegistryMirror{"example/", "registry.corp.local/example/"}, false},
+		{"=registry.corp.local/", RegistryMirror{}, true},
+		{"dexec/=", RegistryMirror{}, true},
+		{"registry.corp.local/dexec@sha256", RegistryMirror{}, true},
+	}
+	for _, c := range cases {
+		gotMirror, err := ParseMirror(c.value)
+		if (err != nil) != c.wantError {
+			t.Errorf("ParseMirror(%q) unexpected error %v", c.value, err)
+		} else if !c.wantError && gotMirror != c.wantMirror {
+			t.Errorf("ParseMirror(%q) %v != %v", c.value, gotMirror, c.wantMirror)
+		}
+	}
+}
+
+func TestMirroredImages(t *testing.T) {
+	mirrors := []RegistryMirror{
+		{"dexec/", "registry.corp.local/dexec/"},
+		{"example/", "registry.corp.local/example/"},
+		{"dexec/", "backup.corp.local/"},
+	}
+	cases := []struct {
+		image      string
+		wantImages []string
+	}{
+		{"dexec/lang-c", []string{"registry.corp.local/dexec/lang-c", "backup.corp.local/lang-c", "dexec/lang-c"}},
+		{"example/lang-zig", []string{"registry.corp.local/example/lang-zig", "example/lang-zig"}},
+		{"other/lang-x", []string{"other/lang-x"}},
+	}
+	for _, c := range cases {
+		var gotImages []string
+		for _, image := range MirroredImages(&ContainerImage{Image: c.image, Version: "1.0.0"}, mirrors) {
+			gotImages = append(gotImages, image.Image)
+		}
+		if !reflect.DeepEqual(gotImages, c.wantImages) {
+			t.Errorf("MirroredImages(%q) %q != %q", c.image, gotImages, c.wantImages)
+		}
+	}
+}
+
+func TestCleanImagePattern(t *testing.T) {
+	pattern := CleanImagePattern([]RegistryMirror{
+		{"dexec/", "registry.corp.local:5000/dexec/"},
+		{"example/", "registry.corp.local/example/"},
+	})
+	cases := []struct {
+		tag       string
+		wantMatch bool
+	}{
+		{"dexec/lang-c:1.0.2", true},
+		{"registry.corp.local:5000/dexec/lang-c:1.0.2", true},
+		{"registry.corp.local:5000/dexec/lang-c", true},
+		{"registry.corp.local/example/lang-zig:0.7.1", false},
+		{"other/dexec/lang-c:1.0.2", false},
+		{"registry.corp.local:5000/dexec/other:1.0.2", false},
+	}
+	for _, c := range cases {
+		if gotMatch := pattern.MatchString(c.tag); gotMatch != c.wantMatch {
+			t.Errorf("CleanImagePattern().MatchString(%q) %t != %t", c.tag, gotMatch, c.wantMatch)
+		}
+	}
+}
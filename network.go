@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// The network modes that restrict the other network options. Any mode other
+// than these and bridge is the name of a user-defined Docker network.
+const (
+	networkNone = "none"
+	networkHost = "host"
+)
+
+// hostGateway is the address Docker replaces with the IP of the host in an
+// extra host entry.
+const hostGateway = "host-gateway"
+
+// proxyVariables are the environment variables that are forwarded to the
+// container when it has a network, in both their upper and lower case forms.
+var proxyVariables = []string{"HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "ALL_PROXY", "NO_PROXY"}
+
+var networkNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// NetworkOptions holds the network the container that runs the sources is
+// attached to, which is Docker's default bridge network if Mode is empty,
+// along with its DNS servers and extra /etc/hosts entries.
+type NetworkOptions struct {
+	Mode       string
+	DNS        []string
+	ExtraHosts []string
+}
+
+// NetworkOptionsFromOptions returns the network options given with
+// --network, --dns and --add-host. The network is none, bridge, host or the
+// name of a Docker network and extra hosts are given as <host>:<ip>, where
+// the IP may be host-gateway for the IP of the host.
+func NetworkOptionsFromOptions(options map[OptionType][]string) (NetworkOptions, error) {
+	var networkOptions NetworkOptions
+	if values := options[Network]; len(values) > 0 {
+		if !networkNamePattern.MatchString(values[0]) {
+			return NetworkOptions{}, fmt.Errorf("invalid network %q, expected none, bridge, host or the name of a Docker network", values[0])
+		}
+		networkOptions.Mode = values[0]
+	}
+
+	for _, value := range options[DNS] {
+		if net.ParseIP(value) == nil {
+			return NetworkOptions{}, fmt.Errorf("invalid DNS server %q, expected an IP address", value)
+		}
+		networkOptions.DNS = append(networkOptions.DNS, value)
+	}
+
+	for _, value := range options[AddHost] {
+		separator := strings.Index(value, ":")
+		if separator <= 0 || (net.ParseIP(value[separator+1:]) == nil && value[separator+1:] != hostGateway) {
+			return NetworkOptions{}, fmt.Errorf("invalid extra host %q, expected <host>:<ip> or <host>:host-gateway", value)
+		}
+		networkOptions.ExtraHosts = append(networkOptions.ExtraHosts, value)
+	}
+
+	switch {
+	case networkOptions.Mode == networkNone && len(networkOptions.DNS) > 0:
+		return NetworkOptions{}, fmt.Errorf("--dns cannot be used with --network none")
+	case networkOptions.Mode == networkNone && len(networkOptions.ExtraHosts) > 0:
+		return NetworkOptions{}, fmt.Errorf("--add-host cannot be used with --network none")
+	case networkOptions.Mode == networkHost && len(networkOptions.DNS) > 0:
+		return NetworkOptions{}, fmt.Errorf("--dns cannot be used with --network host, which uses the host's DNS servers")
+	}
+	return networkOptions, nil
+}
+
+// Enabled reports whether the container has a network.
+func (networkOptions NetworkOptions) Enabled() bool {
+	return networkOptions.Mode != networkNone
+}
+
+// Apply attaches the container to the network in its host configuration.
+func (networkOptions NetworkOptions) Apply(hostConfig *docker.HostConfig) {
+	hostConfig.NetworkMode = networkOptions.Mode
+	hostConfig.DNS = networkOptions.DNS
+	hostConfig.ExtraHosts = networkOptions.ExtraHosts
+}
+
+// ProxyEnv returns the proxy variables set in the given environment, as
+// returned by os.Environ, if the container has a network and nothing
+// otherwise.
+func (networkOptions NetworkOptions) ProxyEnv(environ []string) []string {
+	if !networkOptions.Enabled() {
+		return nil
+	}
+
+	proxies := map[string]bool{}
+	for _, name := range proxyVariables {
+		proxies[name] = true
+		proxies[strings.ToLower(name)] = true
+	}
+
+	var env []string
+	for _, variable := range environ {
+		if name := strings.SplitN(variable, "=", 2)[0]; proxies[name] {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
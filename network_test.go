@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestNetworkOptionsFromOptions(t *testing.T) {
+	cases := []struct {
+		options   map[OptionType][]string
+		want      NetworkOptions
+		wantError string
+	}{
+		{map[OptionType][]string{}, NetworkOptions{}, ""},
+		{map[OptionType][]string{Network: {"none"}}, NetworkOptions{Mode: "none"}, ""},
+		{map[OptionType][]string{Network: {"host"}, AddHost: {"db:10.0.0.5"}}, NetworkOptions{Mode: "host", ExtraHosts: []string{"db:10.0.0.5"}}, ""},
+		{
+			map[OptionType][]string{Network: {"ci_net"}, DNS: {"10.0.0.2", "2001:db8::53"}, AddHost: {"db:10.0.0.5", "gw:host-gateway", "v6:::1"}},
+			NetworkOptions{Mode: "ci_net", DNS: []string{"10.0.0.2", "2001:db8::53"}, ExtraHosts: []string{"db:10.0.0.5", "gw:host-gateway", "v6:::1"}},
+			"",
+		},
+		{map[OptionType][]string{Network: {"-bad"}}, NetworkOptions{}, `invalid network "-bad", expected none, bridge, host or the name of a Docker network`},
+		{map[OptionType][]string{DNS: {"dns.corp.local"}}, NetworkOptions{}, `invalid DNS server "dns.corp.local", expected an IP address`},
+		{map[OptionType][]string{AddHost: {"db"}}, NetworkOptions{}, `invalid extra host "db", expected <host>:<ip> or <host>:host-gateway`},
+		{map[OptionType][]string{AddHost: {":10.0.0.5"}}, NetworkOptions{}, `invalid extra host ":10.0.0.5", expected <host>:<ip> or <host>:host-gateway`},
+		{map[OptionType][]string{Network: {"none"}, DNS: {"10.0.0.2"}}, NetworkOptions{}, "--dns cannot be used with --network none"},
+		{map[OptionType][]string{Network: {"none"}, AddHost: {"db:10.0.0.5"}}, NetworkOptions{}, "--add-host cannot be used with --network none"},
+		{map[OptionType][]string{Network: {"host"}, DNS: {"10.0.0.2"}}, NetworkOptions{}, "--dns cannot be used with --network host, which uses the host's DNS servers"},
+	}
+	for _, c := range cases {
+		got, err := NetworkOptionsFromOptions(c.options)
+		if c.wantError != "" {
+			if err == nil || err.Error() != c.wantError {
+				t.Errorf("NetworkOptionsFromOptions(%v) %v != %q", c.options, err, c.wantError)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("NetworkOptionsFromOptions(%v) %+v, %v != %+v", c.options, got, err, c.want)
+		}
+	}
+}
+
+func TestNetworkOptionsApply(t *testing.T) {
+	hostConfig := &docker.HostConfig{}
+	NetworkOptions{Mode: "ci", DNS: []string{"10.0.0.2"}, ExtraHosts: []string{"db:10.0.0.5"}}.Apply(hostConfig)
+	want := &docker.HostConfig{NetworkMode: "ci", DNS: []string{"10.0.0.2"}, ExtraHosts: []string{"db:10.0.0.5"}}
+	if !reflect.DeepEqual(hostConfig, want) {
+		t.Errorf("Apply() %+v != %+v", hostConfig, want)
+	}
+}
+
+func TestNetworkOptionsProxyEnv(t *testing.T) {
+	environ := []string{
+		"HOME=/home/dexec",
+		"HTTP_PROXY=http://proxy.corp.local:3128",
+		"https_proxy=http://proxy.corp.local:3128",
+		"NO_PROXY=localhost,.corp.local",
+		"HTTP_PROXY_USER=ignored",
+	}
+	cases := []struct {
+		networkOptions NetworkOptions
+		want           []string
+	}{
+		{NetworkOptions{}, []string{"HTTP_PROXY=http://proxy.corp.local:3128", "https_proxy=http://proxy.corp.local:3128", "NO_PROXY=localhost,.corp.local"}},
+		{NetworkOptions{Mode: "host"}, []string{"HTTP_PROXY=http://proxy.corp.local:3128", "https_proxy=http://proxy.corp.local:3128", "NO_PROXY=localhost,.corp.local"}},
+		{NetworkOptions{Mode: "none"}, nil},
+	}
+	for _, c := range cases {
+		if got := c.networkOptions.ProxyEnv(environ); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ProxyEnv() with network %q %q != %q", c.networkOptions.Mode, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(?:/[a-z0-9]+)?$`)
+
+// binfmtMiscDir is where the Linux kernel lists the interpreters registered
+// for foreign binaries, which is how Docker runs images built for another
+// architecture.
+var binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// kernelArchitectures maps the architecture names reported by the Docker
+// host's kernel to the names used for image platforms.
+var kernelArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"aarch64": "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// qemuArchitectures maps image architectures to the names of the QEMU
+// emulators registered with binfmt_misc for them.
+var qemuArchitectures = map[string]string{
+	"amd64": "x86_64",
+	"arm64": "aarch64",
+	"386":   "i386",
+}
+
+// PlatformError is returned when an image is built for an architecture that
+// the Docker host can neither run natively nor emulate.
+type PlatformError struct {
+	Reference     string
+	ImagePlatform string
+	HostPlatform  string
+}
+
+func (e *PlatformError) Error() string {
+	arch := e.ImagePlatform[strings.Index(e.ImagePlatform, "/")+1:]
+	return fmt.Sprintf("image %s is built for %s but the Docker host is %s and has no emulator for %s registered; "+
+		"install one with 'docker run --privileged --rm tonistiigi/binfmt --install %s' or use --platform %s",
+		e.Reference, e.ImagePlatform, e.HostPlatform, arch, arch, e.HostPlatform)
+}
+
+// ParsePlatform checks that a platform is given as os/arch or
+// os/arch/variant, e.g. linux/arm64 or linux/arm/v7.
+func ParsePlatform(platform string) (string, error) {
+	if !platformPattern.MatchString(platform) {
+		return "", fmt.Errorf("invalid platform %q, expected os/arch[/variant] e.g. linux/arm64", platform)
+	}
+	return platform, nil
+}
+
+// platformFor returns the platform to pull an image for, which is the one
+// given with --platform if any or otherwise the image's own.
+func (pullOptions PullOptions) platformFor(image *ContainerImage) string {
+	if pullOptions.Platform != "" {
+		return pullOptions.Platform
+	}
+	return image.Platform
+}
+
+// ImagePlatform returns the os/arch platform of a local image.
+func ImagePlatform(image *docker.Image) string {
+	return fmt.Sprintf("%s/%s", image.OS, image.Architecture)
+}
+
+// HostPlatform returns the os/arch platform of the Docker host.
+func HostPlatform(info *docker.DockerInfo) string {
+	arch := info.Architecture
+	if normalised, ok := kernelArchitectures[arch]; ok {
+		arch = normalised
+	}
+	return fmt.Sprintf("%s/%s", info.OSType, arch)
+}
+
+// MatchesPlatform reports whether a local image is built for the given
+// platform. Any image matches if no platform is given. The variant, if any,
+// is ignored as local images don't record it.
+func MatchesPlatform(image *docker.Image, platform string) bool {
+	if platform == "" {
+		return true
+	}
+	parts := strings.SplitN(platform, "/", 3)
+	return image.OS == parts[0] && image.Architecture == parts[1]
+}
+
+// EmulationAvailable reports whether the Docker host can run images built for
+// the given architecture and whether that is known at all. Docker Desktop
+// always can. A Linux host is checked for a QEMU emulator registered with
+// binfmt_misc, which is only possible if the Docker host is this machine.
+func EmulationAvailable(arch string, info *docker.DockerInfo) (available bool, known bool) {
+	if strings.Contains(info.OperatingSystem, "Docker Desktop") {
+		return true, true
+	}
+	if host := os.Getenv("DOCKER_HOST"); runtime.GOOS != "linux" || host != "" && !strings.HasPrefix(host, "unix://") {
+		return false, false
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s/status", binfmtMiscDir)); err != nil {
+		return false, false
+	}
+	emulator, ok := qemuArchitectures[arch]
+	if !ok {
+		emulator = arch
+	}
+	content, err := ioutil.ReadFile(fmt.Sprintf("%s/qemu-%s", binfmtMiscDir, emulator))
+	if os.IsNotExist(err) {
+		return false, true
+	} else if err != nil {
+		return false, false
+	}
+	return strings.HasPrefix(string(content), "enabled"), true
+}
+
+// CheckPlatform compares the platform of a local image with that of the
+// Docker host before it is run. An image that needs emulation which the host
+// doesn't have results in a PlatformError, rather than the container failing
+// with 'exec format error'. Otherwise a notice is written if the image will
+// be emulated without a platform having been asked for.
+func CheckPlatform(reference string, image *docker.Image, requested string, info *docker.DockerInfo, notices io.Writer) error {
+	imagePlatform, hostPlatform := ImagePlatform(image), HostPlatform(info)
+	if image.Architecture == "" || imagePlatform == hostPlatform {
+		return nil
+	}
+
+	available, known := EmulationAvailable(image.Architecture, info)
+	if known && !available {
+		return &PlatformError{reference, imagePlatform, hostPlatform}
+	}
+	if requested == "" {
+		if known {
+			fmt.Fprintf(notices, "dexec: image %s is built for %s, running it under emulation on %s\n", reference, imagePlatform, hostPlatform)
+		} else {
+			fmt.Fprintf(notices, "dexec: image %s is built for %s but the Docker host is %s, it will only run if the host can emulate it\n", reference, imagePlatform, hostPlatform)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestParsePlatform(t *testing.T) {
+	cases := []struct {
+		platform  string
+		wantError bool
+	}{
+		{"linux/amd64", false},
+		{"linux/arm64", false},
+		{"linux/arm/v7", false},
+		{"linux", true},
+		{"linux/arm64/v8/extra", true},
+		{"Linux/AMD64", true},
+	}
+	for _, c := range cases {
+		if _, err := ParsePlatform(c.platform); (err != nil) != c.wantError {
+			t.Errorf("ParsePlatform(%q) unexpected error %v", c.platform, err)
+		}
+	}
+}
+
+func TestHostPlatform(t *testing.T) {
+	cases := []struct {
+		info docker.DockerInfo
+		want string
+	}{
+		{docker.DockerInfo{OSType: "linux", Architecture: "x86_64"}, "linux/amd64"},
+		{docker.DockerInfo{OSType: "linux", Architecture: "aarch64"}, "linux/arm64"},
+		{docker.DockerInfo{OSType: "linux", Architecture: "s390x"}, "linux/s390x"},
+	}
+	for _, c := range cases {
+		if got := HostPlatform(&c.info); got != c.want {
+			t.Errorf("HostPlatform(%s) %q != %q", c.info.Architecture, got, c.want)
+		}
+	}
+}
+
+func TestMatchesPlatform(t *testing.T) {
+	image := &docker.Image{OS: "linux", Architecture: "arm"}
+	cases := []struct {
+		platform string
+		want     bool
+	}{
+		{"", true},
+		{"linux/arm", true},
+		{"linux/arm/v7", true},
+		{"linux/arm64", false},
+		{"windows/arm", false},
+	}
+	for _, c := range cases {
+		if got := MatchesPlatform(image, c.platform); got != c.want {
+			t.Errorf("MatchesPlatform(%q) %t != %t", c.platform, got, c.want)
+		}
+	}
+}
+
+func TestCheckPlatform(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("emulators are only detected on Linux")
+	}
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(original string) { binfmtMiscDir = original }(binfmtMiscDir)
+	binfmtMiscDir = dir
+	defer os.Setenv("DOCKER_HOST", os.Getenv("DOCKER_HOST"))
+	os.Unsetenv("DOCKER_HOST")
+
+	for name, content := range map[string]string{"status": "enabled\n", "qemu-x86_64": "enabled\ninterpreter /usr/bin/qemu-x86_64\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	arm64 := &docker.DockerInfo{OSType: "linux", Architecture: "aarch64"}
+	cases := []struct {
+		image      docker.Image
+		requested  string
+		info       *docker.DockerInfo
+		wantError  bool
+		wantNotice bool
+	}{
+		{docker.Image{OS: "linux", Architecture: "arm64"}, "", arm64, false, false},
+		{docker.Image{OS: "linux", Architecture: "amd64"}, "", arm64, false, true},
+		{docker.Image{OS: "linux", Architecture: "amd64"}, "linux/amd64", arm64, false, false},
+		{docker.Image{OS: "linux", Architecture: "s390x"}, "", arm64, true, false},
+		{docker.Image{OS: "linux", Architecture: "s390x"}, "", &docker.DockerInfo{OSType: "linux", Architecture: "aarch64", OperatingSystem: "Docker Desktop"}, false, true},
+	}
+	for _, c := range cases {
+		var notices bytes.Buffer
+		err := CheckPlatform("dexec/lang-c:1.0.2", &c.image, c.requested, c.info, &notices)
+		if _, ok := err.(*PlatformError); ok != c.wantError {
+			t.Errorf("CheckPlatform(%s, %q) unexpected error %v", ImagePlatform(&c.image), c.requested, err)
+		}
+		if (notices.Len() > 0) != c.wantNotice {
+			t.Errorf("CheckPlatform(%s, %q) notice %q", ImagePlatform(&c.image), c.requested, notices.String())
+		}
+	}
+}
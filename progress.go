@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+)
+
+// progressInterval is the minimum time between progress lines when progress
+// is not displayed on a terminal.
+var progressInterval = 5 * time.Second
+
+// progressBarWidth is the number of characters in a layer's progress bar.
+const progressBarWidth = 30
+
+// pullMessage is a single message in the JSON stream returned by a pull.
+type pullMessage struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"progressDetail"`
+	Error string `json:"error"`
+}
+
+type layerProgress struct {
+	id         string
+	status     string
+	downloaded int64
+	size       int64
+}
+
+// PullProgress reads the JSON stream of a pull written to it and reports the
+// progress of the pull. On a terminal each layer is shown on its own line and
+// redrawn as it changes, otherwise a summary line is written periodically.
+// Errors reported in the stream are kept and returned by Err.
+type PullProgress struct {
+	out       io.Writer
+	reference string
+	terminal  bool
+	buffer    []byte
+	layers    []*layerProgress
+	drawn     int
+	logged    time.Time
+	err       error
+}
+
+// NewPullProgress returns a PullProgress that reports the progress of pulling
+// reference to out. Nothing is reported if out is nil.
+func NewPullProgress(out io.Writer, reference string, terminal bool) *PullProgress {
+	return &PullProgress{
+		out:       out,
+		reference: reference,
+		terminal:  terminal,
+	}
+}
+
+// Write takes part of the JSON stream of a pull and reports the progress of
+// each complete message in it.
+func (progress *PullProgress) Write(p []byte) (int, error) {
+	progress.buffer = append(progress.buffer, p...)
+	for {
+		end := bytes.IndexByte(progress.buffer, '\n')
+		if end < 0 {
+			break
+		}
+		line := bytes.TrimSpace(progress.buffer[:end])
+		progress.buffer = progress.buffer[end+1:]
+
+		var message pullMessage
+		if len(line) == 0 || json.Unmarshal(line, &message) != nil {
+			continue
+		}
+		progress.handle(message)
+	}
+	return len(p), nil
+}
+
+// Err returns the error reported in the stream, if there was one.
+func (progress *PullProgress) Err() error {
+	return progress.err
+}
+
+// Done reports that the pull has finished.
+func (progress *PullProgress) Done() {
+	if progress.out != nil && !progress.terminal {
+		fmt.Fprintf(progress.out, "dexec: pulled %s\n", progress.reference)
+	}
+}
+
+func (progress *PullProgress) handle(message pullMessage) {
+	if message.Error != "" {
+		progress.err = fmt.Errorf("%s", message.Error)
+		return
+	}
+	if message.ID == "" || message.ID == progress.tag() {
+		return
+	}
+
+	layer := progress.layer(message.ID)
+	layer.status = message.Status
+	switch {
+	case message.ProgressDetail.Total > 0 && strings.HasPrefix(message.Status, "Downloading"):
+		layer.downloaded = message.ProgressDetail.Current
+		layer.size = message.ProgressDetail.Total
+	case message.Status == "Download complete":
+		layer.downloaded = layer.size
+	}
+
+	if progress.out == nil {
+		return
+	} else if progress.terminal {
+		progress.draw()
+	} else if time.Since(progress.logged) >= progressInterval {
+		progress.log()
+	}
+}
+
+// tag returns the tag or digest being pulled, which the stream uses as the
+// ID of messages that are not about a layer.
+func (progress *PullProgress) tag() string {
+	if i := strings.Index(progress.reference, "@"); i >= 0 {
+		return progress.reference[i+1:]
+	}
+	return progress.reference[strings.LastIndex(progress.reference, ":")+1:]
+}
+
+func (progress *PullProgress) layer(id string) *layerProgress {
+	for _, layer := range progress.layers {
+		if layer.id == id {
+			return layer
+		}
+	}
+	layer := &layerProgress{id: id}
+	progress.layers = append(progress.layers, layer)
+	return layer
+}
+
+// draw redraws the line of each layer, moving the cursor back up over the
+// lines drawn previously.
+func (progress *PullProgress) draw() {
+	if progress.drawn == 0 {
+		fmt.Fprintf(progress.out, "Pulling %s\n", progress.reference)
+	} else {
+		fmt.Fprintf(progress.out, "\x1b[%dA", progress.drawn)
+	}
+	for _, layer := range progress.layers {
+		fmt.Fprintf(progress.out, "\x1b[2K%s: %s\n", layer.id, layer.describe())
+	}
+	progress.drawn = len(progress.layers)
+}
+
+// log writes a summary of the layers completed and the bytes downloaded.
+func (progress *PullProgress) log() {
+	var complete int
+	var downloaded, size int64
+	for _, layer := range progress.layers {
+		if layer.complete() {
+			complete++
+		}
+		downloaded += layer.downloaded
+		size += layer.size
+	}
+	fmt.Fprintf(progress.out, "dexec: pulling %s: %d/%d layers complete, %s/%s downloaded\n",
+		progress.reference,
+		complete,
+		len(progress.layers),
+		units.HumanSize(float64(downloaded)),
+		units.HumanSize(float64(size)))
+	progress.logged = time.Now()
+}
+
+func (layer *layerProgress) complete() bool {
+	return layer.status == "Pull complete" || layer.status == "Already exists"
+}
+
+// describe returns the status of a layer with a progress bar while it is
+// downloading.
+func (layer *layerProgress) describe() string {
+	if layer.status != "Downloading" || layer.size == 0 {
+		return layer.status
+	}
+	filled := int(int64(progressBarWidth) * layer.downloaded / layer.size)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	return fmt.Sprintf("%-12s[%s%s] %s/%s",
+		layer.status,
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled),
+		units.HumanSize(float64(layer.downloaded)),
+		units.HumanSize(float64(layer.size)))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPullStream = `{"status":"Pulling from dexec/lang-c","id":"1.0.2"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"aaaaaaaaaaaa"}
+{"status":"Already exists","progressDetail":{},"id":"bbbbbbbbbbbb"}
+{"status":"Downloading","progressDetail":{"current":500,"total":1000},"progress":"[=====>     ]","id":"aaaaaaaaaaaa"}
+{"status":"Download complete","progressDetail":{},"id":"aaaaaaaaaaaa"}
+{"status":"Pull complete","progressDetail":{},"id":"aaaaaaaaaaaa"}
+{"status":"Digest: sha256:0123"}
+{"status":"Status: Downloaded newer image for dexec/lang-c:1.0.2"}
+`
+
+func TestPullProgressLog(t *testing.T) {
+	defer func(interval time.Duration) { progressInterval = interval }(progressInterval)
+	progressInterval = 0
+
+	var out bytes.Buffer
+	progress := NewPullProgress(&out, "dexec/lang-c:1.0.2", false)
+	for _, line := range strings.SplitAfter(testPullStream, "\n") {
+		progress.Write([]byte(line[:len(line)/2]))
+		progress.Write([]byte(line[len(line)/2:]))
+	}
+	progress.Done()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"dexec: pulling dexec/lang-c:1.0.2: 0/1 layers complete, 0B/0B downloaded",
+		"dexec: pulling dexec/lang-c:1.0.2: 1/2 layers complete, 0B/0B downloaded",
+		"dexec: pulling dexec/lang-c:1.0.2: 1/2 layers complete, 500B/1kB downloaded",
+		"dexec: pulling dexec/lang-c:1.0.2: 1/2 layers complete, 1kB/1kB downloaded",
+		"dexec: pulling dexec/lang-c:1.0.2: 2/2 layers complete, 1kB/1kB downloaded",
+		"dexec: pulled dexec/lang-c:1.0.2",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("PullProgress output\n%s\n!=\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if err := progress.Err(); err != nil {
+		t.Errorf("PullProgress unexpected error %v", err)
+	}
+}
+
+func TestPullProgressTerminal(t *testing.T) {
+	var out bytes.Buffer
+	progress := NewPullProgress(&out, "dexec/lang-c:1.0.2", true)
+	progress.Write([]byte(testPullStream))
+	progress.Done()
+
+	got := out.String()
+	for _, want := range []string{
+		"Pulling dexec/lang-c:1.0.2\n",
+		"\x1b[2Kaaaaaaaaaaaa: Downloading [===============               ] 500B/1kB\n",
+		"\x1b[2A\x1b[2Kaaaaaaaaaaaa: Pull complete\n\x1b[2Kbbbbbbbbbbbb: Already exists\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("PullProgress output %q does not contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "Digest") || strings.Contains(got, "dexec: pulled") {
+		t.Errorf("PullProgress output %q includes messages that are not about a layer", got)
+	}
+}
+
+func TestPullProgressQuiet(t *testing.T) {
+	progress := NewPullProgress(nil, "dexec/lang-c@sha256:0123", false)
+	progress.Write([]byte(`{"status":"Pulling from dexec/lang-c","id":"sha256:0123"}` + "\r\n"))
+	progress.Write([]byte(`{"errorDetail":{"message":"unauthorized"},"error":"unauthorized"}` + "\r\n"))
+	progress.Done()
+
+	if err := progress.Err(); err == nil || err.Error() != "unauthorized" {
+		t.Errorf("PullProgress.Err() %v != unauthorized", err)
+	}
+	if len(progress.layers) != 0 {
+		t.Errorf("PullProgress counted the digest as a layer")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// pullAttempts is the number of times a pull from a single registry is tried
+// before moving on to the next one, and pullBackoff the delay before the first
+// retry, which doubles on each subsequent retry.
+var pullAttempts = 3
+var pullBackoff = time.Second
+
+// defaultPullJobs is the number of images pulled at the same time by the pull
+// command unless told otherwise.
+const defaultPullJobs = 4
+
+const (
+	pullStatusPulled  = "pulled"
+	pullStatusPresent = "present"
+	pullStatusFailed  = "failed"
+)
+
+// PullOptions holds the settings used when an image has to be pulled: the
+// mirrors to try, the credentials to pull with and where to report progress.
+// Progress is not reported if Progress is nil, and is displayed per layer if
+// Terminal is set. If Offline is set images are never pulled. Platform, if
+// set, overrides the platform of every image.
+type PullOptions struct {
+	Mirrors     []RegistryMirror
+	Credentials *Credentials
+	Progress    io.Writer
+	Terminal    bool
+	Offline     bool
+	Platform    string
+}
+
+// OfflineError is returned when an image is not available locally and
+// pulling it is not allowed.
+type OfflineError struct {
+	Reference string
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("image %s is not available locally and --offline is set; "+
+		"pull it with 'docker pull %s' or load a saved copy with 'docker load -i <archive>' first",
+		e.Reference, e.Reference)
+}
+
+// PullOptionsFromOptions builds the pull options from the CLI options, with
+// progress reported on stderr unless the quiet flag is set. Asking to update
+// images while offline is an error.
+func PullOptionsFromOptions(options map[OptionType][]string) (PullOptions, error) {
+	offline := len(options[OfflineFlag]) > 0
+	if offline && len(options[UpdateFlag]) > 0 {
+		return PullOptions{}, fmt.Errorf("--offline conflicts with --update, images cannot be updated while offline")
+	}
+
+	mirrors, err := ParseMirrors(options[Mirror])
+	if err != nil {
+		return PullOptions{}, err
+	}
+
+	credentials, err := LoadCredentials(options[RegistryAuth], registryAuthHost(options, mirrors))
+	if err != nil {
+		return PullOptions{}, err
+	}
+
+	pullOptions := PullOptions{Mirrors: mirrors, Credentials: credentials, Offline: offline}
+	if values := options[Platform]; len(values) > 0 {
+		if pullOptions.Platform, err = ParsePlatform(values[0]); err != nil {
+			return PullOptions{}, err
+		}
+	}
+	if len(options[QuietFlag]) == 0 {
+		pullOptions.Progress = os.Stderr
+		pullOptions.Terminal = terminal.IsTerminal(int(os.Stderr.Fd()))
+	}
+	return pullOptions, nil
+}
+
+// registryAuthHost returns the registry that --registry-auth credentials
+// without a host apply to: that of --image if given, otherwise that of the
+// first mirror, or the empty string if there is neither.
+func registryAuthHost(options map[OptionType][]string, mirrors []RegistryMirror) string {
+	if values := options[Image]; len(values) > 0 {
+		return RegistryHost(values[0])
+	}
+	if len(mirrors) > 0 {
+		return RegistryHost(mirrors[0].Prefix)
+	}
+	return ""
+}
+
+// PullError is returned when an image could not be pulled from any of the
+// registries tried. It lists the error for each reference in turn.
+type PullError struct {
+	References []string
+	Errors     []error
+}
+
+func (e *PullError) Error() string {
+	var failures []string
+	for i, reference := range e.References {
+		failures = append(failures, fmt.Sprintf("%s: %s", reference, e.Errors[i]))
+	}
+	return fmt.Sprintf("unable to pull image: %s", strings.Join(failures, "; "))
+}
+
+// PullMirroredImage pulls the first of the images that can be pulled, trying
+// each in order and retrying transient failures with an exponential backoff.
+// Each image is pulled with the credentials for its registry. It returns the
+// image that was pulled.
+func PullMirroredImage(images []*ContainerImage, pullOptions PullOptions, client *docker.Client) (*ContainerImage, error) {
+	pullErr := &PullError{}
+	for _, image := range images {
+		tag := image.Version
+		if image.Digest != "" {
+			tag = image.Digest
+		}
+
+		auth, err := pullOptions.Credentials.Lookup(image.Image)
+		if err != nil {
+			pullErr.References = append(pullErr.References, image.Reference())
+			pullErr.Errors = append(pullErr.Errors, err)
+			continue
+		}
+
+		backoff := pullBackoff
+		for attempt := 1; attempt <= pullAttempts; attempt++ {
+			progress := NewPullProgress(pullOptions.Progress, image.Reference(), pullOptions.Terminal)
+			if err = client.PullImage(docker.PullImageOptions{
+				Repository:    image.Image,
+				Tag:           tag,
+				Platform:      pullOptions.platformFor(image),
+				OutputStream:  progress,
+				RawJSONStream: true,
+			}, auth); err == nil {
+				err = progress.Err()
+			}
+			if err == nil {
+				progress.Done()
+				return image, nil
+			}
+			if !IsTransientError(err) || attempt == pullAttempts {
+				break
+			}
+			if pullOptions.Progress != nil {
+				fmt.Fprintf(pullOptions.Progress, "dexec: pulling %s failed, retrying in %s: %s\n", image.Reference(), backoff, err)
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		pullErr.References = append(pullErr.References, image.Reference())
+		pullErr.Errors = append(pullErr.Errors, err)
+	}
+	return nil, pullErr
+}
+
+// IsTransientError reports whether a pull error is worth retrying, i.e. it is
+// a network error or a server error rather than e.g. an unknown image.
+func IsTransientError(err error) bool {
+	switch e := err.(type) {
+	case *docker.Error:
+		return e.Status >= 500
+	case net.Error:
+		return true
+	default:
+		return false
+	}
+}
+
+// PullResult records the outcome of fetching the image for a language with
+// the pull command.
+type PullResult struct {
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PullImages fetches each of the images using up to jobs workers at the same
+// time and returns the outcome for each image in the order given. Failures
+// are recorded in the results rather than stopping the other pulls.
+func PullImages(images []*ContainerImage, pullOptions PullOptions, update bool, jobs int, client *docker.Client) []PullResult {
+	results := make([]PullResult, len(images))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < jobs && worker < len(images); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = pullImage(images[i], pullOptions, update, client)
+			}
+		}()
+	}
+	for i := range images {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return results
+}
+
+func pullImage(image *ContainerImage, pullOptions PullOptions, update bool, client *docker.Client) PullResult {
+	result := PullResult{Name: image.Name, Image: image.Reference()}
+
+	if !update {
+		local, inspected, err := FindLocalImage(image, pullOptions.Mirrors, client)
+		if err != nil {
+			result.Status, result.Error = pullStatusFailed, err.Error()
+			return result
+		} else if local != nil && MatchesPlatform(inspected, pullOptions.platformFor(image)) {
+			result.Image, result.Status = local.Reference(), pullStatusPresent
+			if err := VerifyImageDigest(local, inspected.RepoDigests); err != nil {
+				result.Status, result.Error = pullStatusFailed, err.Error()
+			}
+			return result
+		}
+	}
+
+	local, err := FetchImage(image, pullOptions, true, client)
+	if err != nil {
+		result.Status, result.Error = pullStatusFailed, err.Error()
+		return result
+	}
+	result.Image, result.Status = local.Reference(), pullStatusPulled
+	return result
+}
+
+// PullFailed reports whether any of the images could not be fetched.
+func PullFailed(results []PullResult) bool {
+	for _, result := range results {
+		if result.Status == pullStatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayPullResults writes the outcome for each image either as a table or
+// as JSON.
+func DisplayPullResults(w io.Writer, results []PullResult, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	}
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tIMAGE\tSTATUS")
+	for _, result := range results {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", result.Name, result.Image, result.Status)
+	}
+	return table.Flush()
+}
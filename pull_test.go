@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestPullMirroredImage(t *testing.T) {
+	defer func(backoff time.Duration) { pullBackoff = backoff }(pullBackoff)
+	pullBackoff = time.Millisecond
+
+	cases := []struct {
+		responses    map[string][]int
+		wantImage    string
+		wantRequests []string
+		wantError    bool
+	}{
+		{
+			map[string][]int{},
+			"registry.corp.local/dexec/lang-c",
+			[]string{"registry.corp.local/dexec/lang-c:1.0.2"},
+			false,
+		},
+		{
+			map[string][]int{"registry.corp.local/dexec/lang-c": {500, 503}},
+			"registry.corp.local/dexec/lang-c",
+			[]string{"registry.corp.local/dexec/lang-c:1.0.2", "registry.corp.local/dexec/lang-c:1.0.2", "registry.corp.local/dexec/lang-c:1.0.2"},
+			false,
+		},
+		{
+			map[string][]int{"registry.corp.local/dexec/lang-c": {404}},
+			"dexec/lang-c",
+			[]string{"registry.corp.local/dexec/lang-c:1.0.2", "dexec/lang-c:1.0.2"},
+			false,
+		},
+		{
+			map[string][]int{"registry.corp.local/dexec/lang-c": {200}},
+			"dexec/lang-c",
+			[]string{"registry.corp.local/dexec/lang-c:1.0.2", "dexec/lang-c:1.0.2"},
+			false,
+		},
+		{
+			map[string][]int{"registry.corp.local/dexec/lang-c": {500, 500, 500}, "dexec/lang-c": {403}},
+			"",
+			[]string{"registry.corp.local/dexec/lang-c:1.0.2", "registry.corp.local/dexec/lang-c:1.0.2", "registry.corp.local/dexec/lang-c:1.0.2", "dexec/lang-c:1.0.2"},
+			true,
+		},
+	}
+	for _, c := range cases {
+		var gotRequests []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/images/create") {
+				http.NotFound(w, r)
+				return
+			}
+			image := r.URL.Query().Get("fromImage")
+			gotRequests = append(gotRequests, image+":"+r.URL.Query().Get("tag"))
+			if statuses := c.responses[image]; len(statuses) > 0 {
+				c.responses[image] = statuses[1:]
+				if statuses[0] == http.StatusOK {
+					w.Write([]byte(`{"status":"Pulling from dexec/lang-c","id":"1.0.2"}` + "\r\n" + `{"error":"manifest unknown"}` + "\r\n"))
+				} else {
+					http.Error(w, `{"message":"pull failed"}`, statuses[0])
+				}
+				return
+			}
+			w.Write([]byte(`{"status":"Downloaded newer image"}`))
+		}))
+		client, err := docker.NewClient(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		images := MirroredImages(&ContainerImage{Image: "dexec/lang-c", Version: "1.0.2"}, []RegistryMirror{{"dexec/", "registry.corp.local/dexec/"}})
+		gotImage, err := PullMirroredImage(images, PullOptions{}, client)
+		server.Close()
+
+		if c.wantError {
+			if pullErr, ok := err.(*PullError); !ok || len(pullErr.References) != 2 {
+				t.Errorf("PullMirroredImage(%v) unexpected error %v", c.responses, err)
+			}
+		} else if err != nil {
+			t.Errorf("PullMirroredImage(%v) unexpected error %v", c.responses, err)
+		} else if gotImage.Image != c.wantImage {
+			t.Errorf("PullMirroredImage(%v) %q != %q", c.responses, gotImage.Image, c.wantImage)
+		}
+		if !reflect.DeepEqual(gotRequests, c.wantRequests) {
+			t.Errorf("PullMirroredImage(%v) requests %q != %q", c.responses, gotRequests, c.wantRequests)
+		}
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&docker.Error{Status: 500, Message: "connection refused"}, true},
+		{&docker.Error{Status: 503, Message: "unavailable"}, true},
+		{&docker.Error{Status: 404, Message: "manifest unknown"}, false},
+		{&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, true},
+		{fmt.Errorf("manifest unknown"), false},
+	}
+	for _, c := range cases {
+		if got := IsTransientError(c.err); got != c.want {
+			t.Errorf("IsTransientError(%v) %t != %t", c.err, got, c.want)
+		}
+	}
+}
+
+func TestPullImages(t *testing.T) {
+	var mutex sync.Mutex
+	var running, maxRunning int
+	present := map[string]bool{"dexec/lang-c:1.0.2": true}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			name := strings.TrimSuffix(r.URL.Path[strings.Index(r.URL.Path, "/images/")+len("/images/"):], "/json")
+			mutex.Lock()
+			found := present[name]
+			mutex.Unlock()
+			if !found {
+				http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"Id":"sha256:abc","RepoDigests":[]}`))
+		case strings.HasSuffix(r.URL.Path, "/images/create"):
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+
+			image := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+			mutex.Lock()
+			running--
+			if image != "dexec/lang-missing:1.0.0" {
+				present[image] = true
+			}
+			mutex.Unlock()
+			if image == "dexec/lang-missing:1.0.0" {
+				http.Error(w, `{"message":"manifest unknown"}`, http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"status":"Downloaded newer image"}` + "\r\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images := []*ContainerImage{
+		{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"},
+		{Name: "Go", Image: "dexec/lang-go", Version: "1.0.1"},
+		{Name: "Missing", Image: "dexec/lang-missing", Version: "1.0.0"},
+		{Name: "Python", Image: "dexec/lang-python3", Version: "1.0.2"},
+		{Name: "Ruby", Image: "dexec/lang-ruby", Version: "1.0.1"},
+	}
+	results := PullImages(images, PullOptions{}, false, 2, client)
+
+	wantStatuses := []string{pullStatusPresent, pullStatusPulled, pullStatusFailed, pullStatusPulled, pullStatusPulled}
+	for i, result := range results {
+		if result.Name != images[i].Name || result.Status != wantStatuses[i] {
+			t.Errorf("PullImages() result %d %s %s != %s %s", i, result.Name, result.Status, images[i].Name, wantStatuses[i])
+		}
+	}
+	if results[2].Error == "" {
+		t.Errorf("PullImages() result for a missing image has no error")
+	}
+	if !PullFailed(results) {
+		t.Errorf("PullFailed() false for results with a failure")
+	}
+	if maxRunning > 2 {
+		t.Errorf("PullImages() ran %d pulls at once with 2 jobs", maxRunning)
+	}
+}
+
+func TestDisplayPullResults(t *testing.T) {
+	results := []PullResult{
+		{Name: "C", Image: "dexec/lang-c:1.0.2", Status: pullStatusPresent},
+		{Name: "Missing", Image: "dexec/lang-missing:1.0.0", Status: pullStatusFailed, Error: "manifest unknown"},
+	}
+
+	var table bytes.Buffer
+	if err := DisplayPullResults(&table, results, false); err != nil {
+		t.Fatal(err)
+	}
+	wantTable := "NAME     IMAGE                     STATUS\n" +
+		"C        dexec/lang-c:1.0.2        present\n" +
+		"Missing  dexec/lang-missing:1.0.0  failed\n"
+	if table.String() != wantTable {
+		t.Errorf("DisplayPullResults() %q != %q", table.String(), wantTable)
+	}
+
+	var output bytes.Buffer
+	if err := DisplayPullResults(&output, results, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), `"error": "manifest unknown"`) || strings.Count(output.String(), `"error"`) != 1 {
+		t.Errorf("DisplayPullResults() JSON %s", output.String())
+	}
+}
+
+func TestPullOptionsFromOptionsOfflineUpdate(t *testing.T) {
+	options := map[OptionType][]string{OfflineFlag: {""}, UpdateFlag: {""}}
+	if _, err := PullOptionsFromOptions(options); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("PullOptionsFromOptions(%v) expected a conflict, got %v", options, err)
+	}
+}
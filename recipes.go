@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LanguageRecipe runs a language with an official toolchain image, which
+// doesn't have the dexec entrypoint, by means of a command template. Version
+// is the tag used unless another toolchain version is chosen with
+// --lang-version.
+type LanguageRecipe struct {
+	Name       string
+	Extensions []string
+	Image      string
+	Version    string
+	Command    string
+}
+
+// innerRecipes are the built-in recipes. Those for languages that have a
+// dexec image are only used once a toolchain version is chosen for them,
+// while the others are added to the registry as they are, after any other
+// languages for the same extension. Official images are used where they
+// exist, otherwise a widely used community image, such as gnuoctave/octave,
+// zenika/kotlin or euantorano/zig, pinned to a release.
+var innerRecipes = []LanguageRecipe{
+	{Name: "Bash", Extensions: []string{"sh"}, Image: "bash", Version: "5.2", Command: "bash {build-args} {source} {args}"},
+	{Name: "C", Extensions: []string{"c"}, Image: "gcc", Version: "14", Command: "sh -c 'gcc {build-args} {sources} -o /tmp/main && /tmp/main {args}'"},
+	{Name: "C++", Extensions: []string{"cpp"}, Image: "gcc", Version: "14", Command: "sh -c 'g++ {build-args} {sources} -o /tmp/main && /tmp/main {args}'"},
+	{Name: "Dart", Extensions: []string{"dart"}, Image: "dart", Version: "3.4", Command: "dart run {build-args} {source} {args}"},
+	{Name: "Elixir", Extensions: []string{"ex", "exs"}, Image: "elixir", Version: "1.16", Command: "elixir {build-args} {source} {args}"},
+	{Name: "Go", Extensions: []string{"go"}, Image: "golang", Version: "1.22", Command: "go run {build-args} {sources} {args}"},
+	{Name: "Haskell", Extensions: []string{"hs"}, Image: "haskell", Version: "9.8", Command: "sh -c 'ghc {build-args} -outputdir /tmp/ghc -o /tmp/main {sources} && /tmp/main {args}'"},
+	{Name: "Java", Extensions: []string{"java"}, Image: "eclipse-temurin", Version: "21", Command: "java {build-args} {source} {args}"},
+	{Name: "JavaScript", Extensions: []string{"js"}, Image: "node", Version: "20", Command: "node {build-args} {source} {args}"},
+	{Name: "Julia", Extensions: []string{"jl"}, Image: "julia", Version: "1.10", Command: "julia {build-args} {source} {args}"},
+	{Name: "Kotlin", Extensions: []string{"kt"}, Image: "zenika/kotlin", Version: "1.4.20", Command: "sh -c 'kotlinc {build-args} {sources} -include-runtime -d /tmp/main.jar && java -jar /tmp/main.jar {args}'"},
+	{Name: "Octave", Extensions: []string{"m"}, Image: "gnuoctave/octave", Version: "9.2.0", Command: "octave-cli {build-args} {source} {args}"},
+	{Name: "Perl", Extensions: []string{"pl"}, Image: "perl", Version: "5.38", Command: "perl {build-args} {source} {args}"},
+	{Name: "PHP", Extensions: []string{"php"}, Image: "php", Version: "8.3", Command: "php {build-args} {source} {args}"},
+	{Name: "Prolog", Extensions: []string{"pl"}, Image: "swipl", Version: "9.2.9", Command: "swipl -t halt {build-args} {source} {args}"},
+	{Name: "Python", Extensions: []string{"py"}, Image: "python", Version: "3.12", Command: "python {build-args} {source} {args}"},
+	{Name: "R", Extensions: []string{"r"}, Image: "r-base", Version: "4.4.0", Command: "Rscript {build-args} {source} {args}"},
+	{Name: "Ruby", Extensions: []string{"rb"}, Image: "ruby", Version: "3.3", Command: "ruby {build-args} {source} {args}"},
+	{Name: "Rust", Extensions: []string{"rs"}, Image: "rust", Version: "1.80", Command: "sh -c 'rustc {build-args} -o /tmp/main {source} && /tmp/main {args}'"},
+	{Name: "Swift", Extensions: []string{"swift"}, Image: "swift", Version: "5.10", Command: "sh -c 'swiftc {build-args} {sources} -o /tmp/main && /tmp/main {args}'"},
+	{Name: "TypeScript", Extensions: []string{"ts"}, Image: "denoland/deno", Version: "1.46.3", Command: "deno run --allow-all {build-args} {source} {args}"},
+	{Name: "Zig", Extensions: []string{"zig"}, Image: "euantorano/zig", Version: "0.11.0", Command: "zig run {build-args} {source} -- {args}"},
+}
+
+var languageVersionPattern = regexp.MustCompile(`^([^=]+)=([\w][\w.-]*)$`)
+
+// AddRecipes returns the registry with the recipes for languages that aren't
+// in it added to it. The registry is not modified.
+func AddRecipes(base map[string][]*ContainerImage, recipes []LanguageRecipe) map[string][]*ContainerImage {
+	added := map[string][]*ContainerImage{}
+	for extension, images := range base {
+		added[extension] = images
+	}
+	for _, recipe := range recipes {
+		if len(extensionsForName(base, recipe.Name)) > 0 {
+			continue
+		}
+		for _, extension := range recipe.Extensions {
+			added[extension] = append(added[extension], &ContainerImage{
+				Name:      recipe.Name,
+				Extension: extension,
+				Image:     recipe.Image,
+				Version:   recipe.Version,
+				Command:   recipe.Command,
+			})
+		}
+	}
+	return added
+}
+
+// LookupRecipe returns the built-in recipe for the language with the given
+// name, compared case-insensitively, or nil if there isn't one.
+func LookupRecipe(name string) *LanguageRecipe {
+	for i, recipe := range innerRecipes {
+		if strings.EqualFold(recipe.Name, name) {
+			return &innerRecipes[i]
+		}
+	}
+	return nil
+}
+
+// ApplyLanguageVersions chooses the toolchain versions given with
+// --lang-version as <language>=<version>, where the language is given by name
+// or extension. A language that is already run with a command template keeps
+// its image with the version changed. A language run with a dexec image is
+// switched to its recipe at the given version, or if it has no recipe the
+// version of its dexec image is changed.
+func ApplyLanguageVersions(versions []string) error {
+	for _, value := range versions {
+		match := languageVersionPattern.FindStringSubmatch(value)
+		if match == nil {
+			return fmt.Errorf("invalid language version %q, expected <language>=<version> e.g. python=3.12", value)
+		}
+		image, err := LookupImageByLanguage(match[1])
+		if err != nil {
+			return err
+		}
+
+		entry := LanguageEntry{Name: image.Name, Version: match[2]}
+		if image.Command == "" {
+			if recipe := LookupRecipe(image.Name); recipe != nil {
+				entry.Image, entry.Command = recipe.Image, recipe.Command
+			}
+		}
+		merged, err := MergeLanguageEntries(registry, []LanguageEntry{entry})
+		if err != nil {
+			return err
+		}
+		registry = merged
+	}
+	return nil
+}
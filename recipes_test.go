@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddRecipes(t *testing.T) {
+	base := map[string][]*ContainerImage{
+		"py": {{Name: "Python", Extension: "py", Image: "dexec/lang-python", Version: "1.0.2"}},
+	}
+	recipes := []LanguageRecipe{
+		{Name: "Python", Extensions: []string{"py"}, Image: "python", Version: "3.12", Command: "python {source} {args}"},
+		{Name: "Elixir", Extensions: []string{"ex", "exs"}, Image: "elixir", Version: "1.16", Command: "elixir {source} {args}"},
+	}
+	want := map[string][]*ContainerImage{
+		"py":  {{Name: "Python", Extension: "py", Image: "dexec/lang-python", Version: "1.0.2"}},
+		"ex":  {{Name: "Elixir", Extension: "ex", Image: "elixir", Version: "1.16", Command: "elixir {source} {args}"}},
+		"exs": {{Name: "Elixir", Extension: "exs", Image: "elixir", Version: "1.16", Command: "elixir {source} {args}"}},
+	}
+	if got := AddRecipes(base, recipes); !reflect.DeepEqual(got, want) {
+		t.Errorf("AddRecipes() %v != %v", got, want)
+	}
+	if len(base) != 1 {
+		t.Errorf("AddRecipes() modified the base registry %v", base)
+	}
+}
+
+func TestBuiltinRecipes(t *testing.T) {
+	for _, extension := range []string{"ts", "swift", "jl", "ex", "exs", "dart", "kt", "zig"} {
+		image, err := LookupImageByExtension(extension)
+		if err != nil {
+			t.Errorf("LookupImageByExtension(%q) unexpected error %v", extension, err)
+		} else if image.Command == "" {
+			t.Errorf("LookupImageByExtension(%q) %+v has no command", extension, image)
+		}
+	}
+	for _, recipe := range innerRecipes {
+		if _, err := ExpandCommand(recipe.Command, []string{"main"}, nil, nil); err != nil {
+			t.Errorf("recipe for %s has an invalid command: %v", recipe.Name, err)
+		}
+	}
+	for extension, want := range map[string][]string{"m": {"Objective C", "Octave"}, "pl": {"Perl", "Prolog"}, "h": {"C", "C++"}} {
+		if got := candidateNames(LookupCandidatesByExtension(extension)); !reflect.DeepEqual(got, want) {
+			t.Errorf("LookupCandidatesByExtension(%q) %q != %q", extension, got, want)
+		}
+	}
+	if image, _ := LookupImageByExtension("py"); image.Image != "dexec/lang-python" {
+		t.Errorf("LookupImageByExtension(\"py\") %+v is not the dexec image", image)
+	}
+}
+
+func TestApplyLanguageVersions(t *testing.T) {
+	defer func(saved map[string][]*ContainerImage) { registry = saved }(registry)
+	registry = AddRecipes(NewRegistry(map[string]*ContainerImage{
+		"py":  {Name: "Python", Extension: "py", Image: "dexec/lang-python", Version: "1.0.2", Digest: testDigest},
+		"lua": {Name: "Lua", Extension: "lua", Image: "dexec/lang-lua", Version: "1.0.1"},
+	}, nil), innerRecipes)
+
+	if err := ApplyLanguageVersions([]string{"python=3.11", "jl=1.9", "Lua=1.0.2"}); err != nil {
+		t.Fatalf("ApplyLanguageVersions() unexpected error %v", err)
+	}
+	cases := []struct {
+		extension string
+		want      ContainerImage
+	}{
+		{"py", ContainerImage{Name: "Python", Extension: "py", Image: "python", Version: "3.11", Command: LookupRecipe("python").Command}},
+		{"jl", ContainerImage{Name: "Julia", Extension: "jl", Image: "julia", Version: "1.9", Command: LookupRecipe("julia").Command}},
+		{"lua", ContainerImage{Name: "Lua", Extension: "lua", Image: "dexec/lang-lua", Version: "1.0.2"}},
+	}
+	for _, c := range cases {
+		if got := registry[c.extension][0]; !reflect.DeepEqual(*got, c.want) {
+			t.Errorf("ApplyLanguageVersions() %s %+v != %+v", c.extension, *got, c.want)
+		}
+	}
+
+	for _, value := range []string{"python", "python=", "=3.12", "python=3.12 beta", "cobol=85"} {
+		if err := ApplyLanguageVersions([]string{value}); err == nil {
+			t.Errorf("ApplyLanguageVersions(%q) expected error", value)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const languagesFilename = "languages.yaml"
+
+// LanguageEntry defines a single entry in the user's language registry file.
+// An entry whose name matches an existing language overrides it, otherwise
+// it adds a new language. Setting Disabled removes the language, or only the
+// listed extensions of it, from the registry. Platform sets the os/arch the
+// language's image is pulled for, Build customises it with a Dockerfile and
+// Command is the template used to run images without the dexec entrypoint.
+// Env sets environment variables for the language, which are merged with
+// those it already has. Line is the line of the entry's name and
+// ExtensionLines the line of each extension, for reporting errors.
+type LanguageEntry struct {
+	Name           string
+	Extensions     []string
+	Image          string
+	Version        string
+	Digest         string
+	Platform       string
+	Build          *ImageBuild
+	Command        string
+	Env            []string
+	Disabled       bool
+	Line           int
+	ExtensionLines []int
+}
+
+// registry maps each extension to its candidate images, default first. It is
+// built from innerMap, innerCandidates and the recipes for languages without
+// a dexec image and then merged with the user's language registry file, if
+// one exists.
+var registry = AddRecipes(NewRegistry(innerMap, innerCandidates), innerRecipes)
+
+// NewRegistry builds a map of extensions to candidate images from a map with
+// a single image per extension and a map of additional candidates.
+func NewRegistry(images map[string]*ContainerImage, candidates map[string][]*ContainerImage) map[string][]*ContainerImage {
+	built := map[string][]*ContainerImage{}
+	for extension, image := range images {
+		built[extension] = []*ContainerImage{image}
+	}
+	for extension, images := range candidates {
+		for _, image := range images {
+			if !containsLanguage(built[extension], image.Name) {
+				built[extension] = append(built[extension], image)
+			}
+		}
+	}
+	return built
+}
+
+// registryExtensions returns the extensions in the registry in sorted order.
+func registryExtensions() []string {
+	var extensions []string
+	for extension := range registry {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// LoadUserRegistry reads the language registry file in the dexec config
+// directory, if present, and merges it into the registry used by
+// LookupImageByExtension and LookupImageByName.
+func LoadUserRegistry() error {
+	filename := filepath.Join(ConfigDir(), languagesFilename)
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	entries, err := ParseLanguageEntries(filename, content)
+	if err != nil {
+		return err
+	}
+
+	merged, err := MergeLanguageEntries(registry, entries)
+	if err != nil {
+		return fmt.Errorf("%s:%s", filename, err)
+	}
+	registry = merged
+	return nil
+}
+
+// ParseLanguageEntries takes the name and content of a language registry
+// file and returns the entries it defines. Errors are prefixed with the
+// filename and the line on which the problem was found.
+func ParseLanguageEntries(filename string, content []byte) ([]LanguageEntry, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping with a languages key", filename, root.Line)
+	}
+
+	var entries []LanguageEntry
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "languages" {
+			return nil, fmt.Errorf("%s:%d: unknown key %q", filename, key.Line, key.Value)
+		}
+		if value.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("%s:%d: languages must be a list", filename, value.Line)
+		}
+		for _, item := range value.Content {
+			entry, err := parseLanguageEntry(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s", filename, err)
+			}
+			if entry.Build != nil {
+				entry.Build.Dockerfile = resolveRegistryPath(filename, entry.Build.Dockerfile)
+				entry.Build.Context = resolveRegistryPath(filename, entry.Build.Context)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func parseLanguageEntry(node *yaml.Node) (LanguageEntry, error) {
+	entry := LanguageEntry{Line: node.Line}
+	if node.Kind != yaml.MappingNode {
+		return entry, fmt.Errorf("%d: language entry must be a mapping", node.Line)
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "name":
+			err = value.Decode(&entry.Name)
+			entry.Line = key.Line
+		case "extensions":
+			entry.Extensions, err = decodeStringList(value)
+			entry.ExtensionLines = stringListLines(value)
+		case "image":
+			err = value.Decode(&entry.Image)
+		case "version":
+			err = value.Decode(&entry.Version)
+		case "digest":
+			err = value.Decode(&entry.Digest)
+		case "platform":
+			err = value.Decode(&entry.Platform)
+		case "command":
+			err = value.Decode(&entry.Command)
+		case "env":
+			if entry.Env, err = parseEnv(value); err != nil {
+				return entry, err
+			}
+		case "disabled":
+			err = value.Decode(&entry.Disabled)
+		case "build":
+			if entry.Build, err = parseImageBuild(value); err != nil {
+				return entry, err
+			}
+		default:
+			return entry, fmt.Errorf("%d: unknown key %q", key.Line, key.Value)
+		}
+		if err != nil {
+			return entry, fmt.Errorf("%d: invalid value for %s", value.Line, key.Value)
+		}
+	}
+
+	if entry.Name == "" {
+		return entry, fmt.Errorf("%d: language entry has no name", node.Line)
+	}
+	if entry.Digest != "" && !digestPattern.MatchString(entry.Digest) {
+		return entry, fmt.Errorf("%d: invalid digest %q for %s, expected sha256:<64 hex digits>", node.Line, entry.Digest, entry.Name)
+	}
+	if entry.Platform != "" {
+		if _, err := ParsePlatform(entry.Platform); err != nil {
+			return entry, fmt.Errorf("%d: %s for %s", node.Line, err, entry.Name)
+		}
+	}
+	if entry.Command != "" {
+		if _, err := SplitArgs(entry.Command); err != nil {
+			return entry, fmt.Errorf("%d: invalid command for %s: %s", node.Line, entry.Name, err)
+		}
+	}
+	for i, extension := range entry.Extensions {
+		if extension == "" || strings.ContainsAny(extension, ". \t") {
+			return entry, fmt.Errorf("%d: invalid extension %q for %s", entry.ExtensionLines[i], extension, entry.Name)
+		}
+	}
+	return entry, nil
+}
+
+// parseImageBuild decodes the build section of a language entry, which has
+// either a dockerfile or inline instructions and optionally a context.
+func parseImageBuild(node *yaml.Node) (*ImageBuild, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%d: build must be a mapping", node.Line)
+	}
+
+	build := &ImageBuild{}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		var err error
+		switch key.Value {
+		case "dockerfile":
+			err = value.Decode(&build.Dockerfile)
+		case "instructions":
+			err = value.Decode(&build.Instructions)
+		case "context":
+			err = value.Decode(&build.Context)
+		default:
+			return nil, fmt.Errorf("%d: unknown key %q in build", key.Line, key.Value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%d: invalid value for build %s", value.Line, key.Value)
+		}
+	}
+
+	if (build.Dockerfile == "") == (build.Instructions == "") {
+		return nil, fmt.Errorf("%d: build must have either a dockerfile or instructions", node.Line)
+	}
+	return build, nil
+}
+
+// parseEnv decodes the environment variables of a language entry, given
+// either as a mapping of names to values or as a list of KEY=VALUE or KEY.
+func parseEnv(node *yaml.Node) ([]string, error) {
+	var env []string
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			var value string
+			if err := node.Content[i+1].Decode(&value); err != nil {
+				return nil, fmt.Errorf("%d: invalid value for env %s", node.Content[i+1].Line, node.Content[i].Value)
+			}
+			env = append(env, node.Content[i].Value+"="+value)
+		}
+	} else if err := node.Decode(&env); err != nil {
+		return nil, fmt.Errorf("%d: env must be a mapping or a list", node.Line)
+	}
+
+	for _, variable := range env {
+		if err := checkEnvVariable(variable); err != nil {
+			return nil, fmt.Errorf("%d: %s", node.Line, err)
+		}
+	}
+	return env, nil
+}
+
+// resolveRegistryPath returns a path given in a language registry file
+// relative to the directory of the file.
+func resolveRegistryPath(filename string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(filename), path)
+}
+
+// decodeStringList accepts either a single scalar or a sequence of scalars
+// and returns them as a string slice.
+func decodeStringList(node *yaml.Node) ([]string, error) {
+	var values []string
+	if node.Kind == yaml.ScalarNode {
+		var value string
+		err := node.Decode(&value)
+		return []string{value}, err
+	}
+	err := node.Decode(&values)
+	return values, err
+}
+
+// stringListLines returns the line of each value in a node accepted by
+// decodeStringList.
+func stringListLines(node *yaml.Node) []int {
+	if node.Kind == yaml.ScalarNode {
+		return []int{node.Line}
+	}
+	var lines []int
+	for _, item := range node.Content {
+		lines = append(lines, item.Line)
+	}
+	return lines
+}
+
+// MergeLanguageEntries applies a list of language entries to a base registry
+// and returns the resulting registry. The base registry is not modified. A
+// new language that uses an existing extension is added as an additional
+// candidate for it rather than replacing the existing languages. An entry
+// that changes a language's image drops the platform, command and build of
+// the old image unless it gives its own. Disabling a language, or an
+// extension of it, that isn't in the registry is an error.
+func MergeLanguageEntries(base map[string][]*ContainerImage, entries []LanguageEntry) (map[string][]*ContainerImage, error) {
+	merged := map[string][]*ContainerImage{}
+	for extension, images := range base {
+		for _, image := range images {
+			copied := *image
+			merged[extension] = append(merged[extension], &copied)
+		}
+	}
+
+	for _, entry := range entries {
+		existing := extensionsForName(merged, entry.Name)
+
+		if entry.Disabled {
+			if len(existing) == 0 {
+				return nil, lineError(entry.Line, "unknown language %s can't be disabled", entry.Name)
+			}
+			targets := entry.Extensions
+			if len(targets) == 0 {
+				targets = existing
+			}
+			for i, extension := range targets {
+				if !containsLanguage(merged[extension], entry.Name) {
+					line := entry.Line
+					if i < len(entry.ExtensionLines) {
+						line = entry.ExtensionLines[i]
+					}
+					return nil, lineError(line, "%s has no extension %s to disable", entry.Name, extension)
+				}
+				if remaining := removeLanguage(merged[extension], entry.Name); len(remaining) > 0 {
+					merged[extension] = remaining
+				} else {
+					delete(merged, extension)
+				}
+			}
+			continue
+		}
+
+		name, image, version, digest, platform, build, command, env := entry.Name, entry.Image, entry.Version, entry.Digest, entry.Platform, entry.Build, entry.Command, entry.Env
+		if len(existing) > 0 {
+			current := findLanguage(merged[existing[0]], entry.Name)
+			name = current.Name
+			env = MergeEnv(current.Env, entry.Env)
+			// The platform, command and build belong to the image, so they
+			// are only kept while the image stays the same.
+			if sameImage := image == "" || image == current.Image; sameImage {
+				if platform == "" {
+					platform = current.Platform
+				}
+				if command == "" {
+					command = current.Command
+				}
+				if build == nil {
+					build = current.Build
+				}
+			}
+			if digest == "" && image == "" && version == "" {
+				digest = current.Digest
+			}
+			if image == "" {
+				image = current.Image
+			}
+			if version == "" {
+				version = current.Version
+			}
+		} else if image == "" {
+			return nil, lineError(entry.Line, "new language %s has no image", entry.Name)
+		} else if len(entry.Extensions) == 0 {
+			return nil, lineError(entry.Line, "new language %s has no extensions", entry.Name)
+		}
+		if version == "" {
+			version = "latest"
+		}
+
+		for _, extension := range JoinStringSlices(existing, entry.Extensions) {
+			updated := &ContainerImage{
+				Name:      name,
+				Extension: extension,
+				Image:     image,
+				Version:   version,
+				Digest:    digest,
+				Platform:  platform,
+				Build:     build,
+				Command:   command,
+				Env:       env,
+			}
+			if current := findLanguage(merged[extension], name); current != nil {
+				*current = *updated
+			} else {
+				merged[extension] = append(merged[extension], updated)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// lineError returns an error prefixed with the line of the language registry
+// file it was found on, or without a prefix for entries that don't come from
+// a file, such as those for --lang-version or labelled images.
+func lineError(line int, format string, args ...interface{}) error {
+	if line == 0 {
+		return fmt.Errorf(format, args...)
+	}
+	return fmt.Errorf("%d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// extensionsForName returns the extensions for which the language with the
+// given name, compared case-insensitively, is a candidate.
+func extensionsForName(images map[string][]*ContainerImage, name string) []string {
+	var extensions []string
+	for extension, candidates := range images {
+		if containsLanguage(candidates, name) {
+			extensions = append(extensions, extension)
+		}
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// findLanguage returns the candidate with the given name, compared
+// case-insensitively, or nil if there isn't one.
+func findLanguage(candidates []*ContainerImage, name string) *ContainerImage {
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate.Name, name) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func containsLanguage(candidates []*ContainerImage, name string) bool {
+	return findLanguage(candidates, name) != nil
+}
+
+// removeLanguage returns the candidates without the one with the given name,
+// compared case-insensitively.
+func removeLanguage(candidates []*ContainerImage, name string) []*ContainerImage {
+	var remaining []*ContainerImage
+	for _, candidate := range candidates {
+		if !strings.EqualFold(candidate.Name, name) {
+			remaining = append(remaining, candidate)
+		}
+	}
+	return remaining
+}
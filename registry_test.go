@@ -0,0 +1,218 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLanguageEntries(t *testing.T) {
+	content := []byte(`languages:
+  - name: C++
+    extensions: [cc, hpp]
+  - name: Python
+    version: 1.0.3
+  - name: Objective C
+    disabled: true
+  - name: JavaScript Modules
+    extensions: mjs
+    image: dexec/lang-node
+  - name: Rust
+    build:
+      instructions: RUN apt-get install -y libssl-dev
+  - name: Haskell
+    platform: linux/amd64
+  - name: Go
+    build:
+      dockerfile: go/Dockerfile
+      context: go
+  - name: TypeScript
+    extensions: ts
+    image: node
+    command: npx -y tsx {sources} {args}
+  - name: Java
+    env:
+      JAVA_TOOL_OPTIONS: -Xmx256m
+      DEBUG: 1
+  - name: Ruby
+    env: [RUBYOPT=-W0, GEM_HOME]
+`)
+	want := []LanguageEntry{
+		{Name: "C++", Extensions: []string{"cc", "hpp"}, Line: 2, ExtensionLines: []int{3, 3}},
+		{Name: "Python", Version: "1.0.3", Line: 4},
+		{Name: "Objective C", Disabled: true, Line: 6},
+		{Name: "JavaScript Modules", Extensions: []string{"mjs"}, Image: "dexec/lang-node", Line: 8, ExtensionLines: []int{9}},
+		{Name: "Rust", Build: &ImageBuild{Instructions: "RUN apt-get install -y libssl-dev"}, Line: 11},
+		{Name: "Haskell", Platform: "linux/amd64", Line: 14},
+		{Name: "Go", Build: &ImageBuild{Dockerfile: "/etc/dexec/go/Dockerfile", Context: "/etc/dexec/go"}, Line: 16},
+		{Name: "TypeScript", Extensions: []string{"ts"}, Image: "node", Command: "npx -y tsx {sources} {args}", Line: 20, ExtensionLines: []int{21}},
+		{Name: "Java", Env: []string{"JAVA_TOOL_OPTIONS=-Xmx256m", "DEBUG=1"}, Line: 24},
+		{Name: "Ruby", Env: []string{"RUBYOPT=-W0", "GEM_HOME"}, Line: 28},
+	}
+	got, err := ParseLanguageEntries("/etc/dexec/languages.yaml", content)
+	if err != nil {
+		t.Fatalf("ParseLanguageEntries unexpected error %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLanguageEntries %v != %v", got, want)
+	}
+}
+
+func TestParseLanguageEntriesErrors(t *testing.T) {
+	cases := []struct {
+		content string
+		want    string
+	}{
+		{"langs: []\n", "languages.yaml:1: unknown key \"langs\""},
+		{"languages: foo\n", "languages.yaml:1: languages must be a list"},
+		{"languages:\n  - name: C\n    colour: red\n", "languages.yaml:3: unknown key \"colour\""},
+		{"languages:\n  - extensions: [c]\n", "languages.yaml:2: language entry has no name"},
+		{"languages:\n  - name: C\n    disabled: maybe\n", "languages.yaml:3: invalid value for disabled"},
+		{"languages:\n  - name: C\n    extensions: [.c]\n", "languages.yaml:3: invalid extension \".c\" for C"},
+		{"languages:\n  - name: C\n    extensions:\n      - c\n      - \"h h\"\n", "languages.yaml:5: invalid extension \"h h\" for C"},
+		{"languages:\n  - name: C\n    digest: abc\n", "languages.yaml:2: invalid digest \"abc\" for C, expected sha256:<64 hex digits>"},
+		{"languages:\n  - name: C\n    platform: amd64\n", "languages.yaml:2: invalid platform \"amd64\", expected os/arch[/variant] e.g. linux/arm64 for C"},
+		{"languages:\n  - name: C\n    command: \"gcc 'main.c\"\n", "languages.yaml:2: invalid command for C: unterminated quote in \"gcc 'main.c\""},
+		{"languages:\n  - name: C\n    env: CC=gcc\n", "languages.yaml:3: env must be a mapping or a list"},
+		{"languages:\n  - name: C\n    env: [\"=gcc\"]\n", "languages.yaml:3: invalid environment variable \"=gcc\", expected KEY=VALUE or KEY"},
+		{"languages:\n  - name: C\n    build: Dockerfile\n", "languages.yaml:3: build must be a mapping"},
+		{"languages:\n  - name: C\n    build:\n      context: .\n", "languages.yaml:4: build must have either a dockerfile or instructions"},
+		{"languages:\n  - name: C\n    build:\n      file: Dockerfile\n", "languages.yaml:4: unknown key \"file\" in build"},
+	}
+	for _, c := range cases {
+		_, err := ParseLanguageEntries("languages.yaml", []byte(c.content))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("ParseLanguageEntries(%q) %v != %q", c.content, err, c.want)
+		}
+	}
+}
+
+func TestMergeLanguageEntries(t *testing.T) {
+	base := map[string][]*ContainerImage{
+		"c":   {{Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2"}},
+		"cpp": {{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2"}},
+		"h":   {{Name: "C", Extension: "h", Image: "dexec/lang-c", Version: "1.0.2"}, {Name: "C++", Extension: "h", Image: "dexec/lang-cpp", Version: "1.0.2"}},
+		"m":   {{Name: "Objective C", Extension: "m", Image: "dexec/lang-objc", Version: "1.0.2"}},
+	}
+	entries := []LanguageEntry{
+		{Name: "c++", Extensions: []string{"cc"}, Version: "1.0.3"},
+		{Name: "Objective C", Disabled: true},
+		{Name: "Octave", Extensions: []string{"m"}, Image: "example/octave", Version: "6"},
+		{Name: "C", Extensions: []string{"h"}, Disabled: true},
+		{Name: "Zig", Extensions: []string{"zig"}, Image: "example/zig"},
+		{Name: "C", Digest: testDigest},
+	}
+	want := map[string][]*ContainerImage{
+		"c":   {{Name: "C", Extension: "c", Image: "dexec/lang-c", Version: "1.0.2", Digest: testDigest}},
+		"cpp": {{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.3"}},
+		"cc":  {{Name: "C++", Extension: "cc", Image: "dexec/lang-cpp", Version: "1.0.3"}},
+		"h":   {{Name: "C++", Extension: "h", Image: "dexec/lang-cpp", Version: "1.0.3"}},
+		"m":   {{Name: "Octave", Extension: "m", Image: "example/octave", Version: "6"}},
+		"zig": {{Name: "Zig", Extension: "zig", Image: "example/zig", Version: "latest"}},
+	}
+	got, err := MergeLanguageEntries(base, entries)
+	if err != nil {
+		t.Fatalf("MergeLanguageEntries unexpected error %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeLanguageEntries %v != %v", got, want)
+	}
+	if base["cpp"][0].Version != "1.0.2" {
+		t.Errorf("MergeLanguageEntries modified base registry")
+	}
+}
+
+func TestMergeLanguageEntriesCandidates(t *testing.T) {
+	base := map[string][]*ContainerImage{
+		"pl": {{Name: "Perl", Extension: "pl", Image: "dexec/lang-perl", Version: "1.0.2"}},
+	}
+	entries := []LanguageEntry{
+		{Name: "Prolog", Extensions: []string{"pl", "pro"}, Image: "example/prolog", Version: "8"},
+	}
+	want := map[string][]*ContainerImage{
+		"pl":  {{Name: "Perl", Extension: "pl", Image: "dexec/lang-perl", Version: "1.0.2"}, {Name: "Prolog", Extension: "pl", Image: "example/prolog", Version: "8"}},
+		"pro": {{Name: "Prolog", Extension: "pro", Image: "example/prolog", Version: "8"}},
+	}
+	got, err := MergeLanguageEntries(base, entries)
+	if err != nil {
+		t.Fatalf("MergeLanguageEntries unexpected error %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeLanguageEntries %v != %v", got, want)
+	}
+}
+
+func TestMergeLanguageEntriesBuild(t *testing.T) {
+	base := map[string][]*ContainerImage{
+		"cpp": {{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.2"}},
+	}
+	build := &ImageBuild{Instructions: "RUN apt-get install -y libboost-dev"}
+	entries := []LanguageEntry{
+		{Name: "C++", Build: build, Command: "sh -c 'g++ {sources} && ./a.out {args}'", Env: []string{"CXX=g++", "DEBUG=0"}},
+		{Name: "C++", Version: "1.0.3", Platform: "linux/amd64", Env: []string{"DEBUG=1"}},
+	}
+	want := map[string][]*ContainerImage{
+		"cpp": {{Name: "C++", Extension: "cpp", Image: "dexec/lang-cpp", Version: "1.0.3", Platform: "linux/amd64", Build: build, Command: "sh -c 'g++ {sources} && ./a.out {args}'", Env: []string{"CXX=g++", "DEBUG=1"}}},
+	}
+	got, err := MergeLanguageEntries(base, entries)
+	if err != nil {
+		t.Fatalf("MergeLanguageEntries unexpected error %q", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeLanguageEntries %v != %v", got, want)
+	}
+}
+
+func TestMergeLanguageEntriesImageChange(t *testing.T) {
+	build := &ImageBuild{Instructions: "RUN true"}
+	base := map[string][]*ContainerImage{
+		"py": {{Name: "Python", Extension: "py", Image: "python", Version: "3.12", Platform: "linux/amd64", Build: build, Command: "python {source} {args}", Env: []string{"PYTHONUNBUFFERED=1"}}},
+	}
+	cases := []struct {
+		entry LanguageEntry
+		want  *ContainerImage
+	}{
+		{
+			LanguageEntry{Name: "Python", Version: "3.11"},
+			&ContainerImage{Name: "Python", Extension: "py", Image: "python", Version: "3.11", Platform: "linux/amd64", Build: build, Command: "python {source} {args}", Env: []string{"PYTHONUNBUFFERED=1"}},
+		},
+		{
+			LanguageEntry{Name: "Python", Image: "python", Version: "3.11"},
+			&ContainerImage{Name: "Python", Extension: "py", Image: "python", Version: "3.11", Platform: "linux/amd64", Build: build, Command: "python {source} {args}", Env: []string{"PYTHONUNBUFFERED=1"}},
+		},
+		{
+			LanguageEntry{Name: "Python", Image: "corp/python", Version: "1.0"},
+			&ContainerImage{Name: "Python", Extension: "py", Image: "corp/python", Version: "1.0", Env: []string{"PYTHONUNBUFFERED=1"}},
+		},
+		{
+			LanguageEntry{Name: "Python", Image: "corp/python", Version: "1.0", Command: "python3 {sources}"},
+			&ContainerImage{Name: "Python", Extension: "py", Image: "corp/python", Version: "1.0", Command: "python3 {sources}", Env: []string{"PYTHONUNBUFFERED=1"}},
+		},
+	}
+	for _, c := range cases {
+		got, err := MergeLanguageEntries(base, []LanguageEntry{c.entry})
+		if err != nil {
+			t.Errorf("MergeLanguageEntries(%+v) unexpected error %v", c.entry, err)
+		} else if !reflect.DeepEqual(got["py"], []*ContainerImage{c.want}) {
+			t.Errorf("MergeLanguageEntries(%+v) %+v != %+v", c.entry, got["py"][0], c.want)
+		}
+	}
+}
+
+func TestMergeLanguageEntriesErrors(t *testing.T) {
+	cases := []struct {
+		entry LanguageEntry
+		want  string
+	}{
+		{LanguageEntry{Name: "Mercury", Extensions: []string{"m"}, Line: 4}, "4: new language Mercury has no image"},
+		{LanguageEntry{Name: "Mercury", Image: "example/mercury", Line: 7}, "7: new language Mercury has no extensions"},
+		{LanguageEntry{Name: "Mercury", Image: "example/mercury"}, "new language Mercury has no extensions"},
+		{LanguageEntry{Name: "Pyhton", Disabled: true, Line: 2}, "2: unknown language Pyhton can't be disabled"},
+		{LanguageEntry{Name: "C", Extensions: []string{"h", "cpp"}, Disabled: true, Line: 5, ExtensionLines: []int{7, 8}}, "8: C has no extension cpp to disable"},
+	}
+	for _, c := range cases {
+		_, err := MergeLanguageEntries(registry, []LanguageEntry{c.entry})
+		if err == nil || err.Error() != c.want {
+			t.Errorf("MergeLanguageEntries(%v) %v != %q", c.entry, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// readOnlyTmpfs is the tmpfs mounted on /tmp when the root filesystem is
+// read-only. It allows programs to be executed from it, as compiled sources
+// are, and the sources and includes are mounted on top of it in
+// /tmp/dexec/build.
+const readOnlyTmpfs = "rw,exec,nosuid,nodev,mode=1777"
+
+var capabilityPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+var runtimePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// sandboxPresets maps the names of the sandbox presets to the options they
+// control. Values given for these options in layers below the one that
+// chose the preset are discarded, and an option without values is left
+// unset, as --cap-add is by the strict preset.
+var sandboxPresets = map[string]map[OptionType][]string{
+	"none": {},
+	"strict": {
+		CapDrop:      {"ALL"},
+		CapAdd:       nil,
+		SecurityOpt:  {"no-new-privileges"},
+		ReadOnlyFlag: {""},
+		Network:      {networkNone},
+		PidsLimit:    {"256"},
+	},
+}
+
+// SecurityOptions holds the hardening applied to the container that runs the
+// sources. If ReadOnly is set the root filesystem is read-only, with a tmpfs
+// on /tmp. Runtime, if set, is the OCI runtime used in place of the default,
+// e.g. runsc for gVisor.
+type SecurityOptions struct {
+	CapDrop     []string
+	CapAdd      []string
+	SecurityOpt []string
+	ReadOnly    bool
+	Runtime     string
+}
+
+// ApplySandbox returns the option layers with a layer for the preset given
+// with --sandbox inserted just below the layer that chose it, or the layers
+// unchanged if there is no preset. Options set in the same or a later layer
+// take precedence over the preset, while those set in an earlier layer are
+// dropped for the options the preset controls, so that e.g. a project file
+// can't loosen --sandbox strict given on the command line.
+func ApplySandbox(layers []OptionLayer) ([]OptionLayer, error) {
+	chosen := -1
+	for i, layer := range layers {
+		if len(layer.Options[Sandbox]) > 0 {
+			chosen = i
+		}
+	}
+	if chosen < 0 {
+		return layers, nil
+	}
+	name := layers[chosen].Options[Sandbox][0]
+	preset, ok := sandboxPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox %q, expected one of %s", name, strings.Join(sandboxNames(), ", "))
+	}
+
+	var applied []OptionLayer
+	for _, layer := range layers[:chosen] {
+		options := map[OptionType][]string{}
+		for optionType, values := range layer.Options {
+			if _, ok := preset[optionType]; !ok {
+				options[optionType] = values
+			}
+		}
+		applied = append(applied, OptionLayer{layer.Origin, options})
+	}
+
+	presetLayer := OptionLayer{fmt.Sprintf("sandbox %s", name), map[OptionType][]string{}}
+	for optionType, values := range preset {
+		if len(values) > 0 {
+			presetLayer.Options[optionType] = values
+		}
+	}
+	applied = append(applied, presetLayer)
+	return append(applied, layers[chosen:]...), nil
+}
+
+func sandboxNames() []string {
+	var names []string
+	for name := range sandboxPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SecurityOptionsFromOptions returns the security options given with
+// --cap-drop, --cap-add, --security-opt, --read-only and --runtime. A
+// seccomp profile given as seccomp=<file> is read from the file, as the
+// Docker API takes the profile itself.
+func SecurityOptionsFromOptions(options map[OptionType][]string) (SecurityOptions, error) {
+	securityOptions := SecurityOptions{ReadOnly: len(options[ReadOnlyFlag]) > 0}
+
+	var err error
+	if securityOptions.CapDrop, err = parseCapabilities(options[CapDrop]); err != nil {
+		return SecurityOptions{}, err
+	}
+	if securityOptions.CapAdd, err = parseCapabilities(options[CapAdd]); err != nil {
+		return SecurityOptions{}, err
+	}
+
+	for _, value := range options[SecurityOpt] {
+		securityOpt, err := parseSecurityOpt(value)
+		if err != nil {
+			return SecurityOptions{}, err
+		}
+		securityOptions.SecurityOpt = append(securityOptions.SecurityOpt, securityOpt)
+	}
+
+	if values := options[Runtime]; len(values) > 0 {
+		if !runtimePattern.MatchString(values[0]) {
+			return SecurityOptions{}, fmt.Errorf("invalid runtime %q", values[0])
+		}
+		securityOptions.Runtime = values[0]
+	}
+	return securityOptions, nil
+}
+
+// parseCapabilities returns the capabilities in the form Docker uses, in
+// upper case with or without the CAP_ prefix, or ALL.
+func parseCapabilities(values []string) ([]string, error) {
+	var capabilities []string
+	for _, value := range values {
+		capability := strings.ToUpper(value)
+		if !capabilityPattern.MatchString(capability) {
+			return nil, fmt.Errorf("invalid capability %q, expected a name such as NET_RAW or ALL", value)
+		}
+		capabilities = append(capabilities, capability)
+	}
+	return capabilities, nil
+}
+
+// parseSecurityOpt checks a security option given as <key>=<value>, or as
+// no-new-privileges on its own, and returns it as it is passed to Docker.
+func parseSecurityOpt(value string) (string, error) {
+	if value == "no-new-privileges" {
+		return value, nil
+	}
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("invalid security option %q, expected <key>=<value> or no-new-privileges", value)
+	}
+
+	switch key, setting := parts[0], parts[1]; key {
+	case "no-new-privileges":
+		if setting != "true" && setting != "false" {
+			return "", fmt.Errorf("invalid security option %q, expected no-new-privileges=true or false", value)
+		}
+	case "apparmor", "label":
+	case "seccomp":
+		if setting == "unconfined" {
+			break
+		}
+		content, err := ioutil.ReadFile(setting)
+		if err != nil {
+			return "", fmt.Errorf("unable to read seccomp profile: %s", err)
+		}
+		var profile bytes.Buffer
+		if err := json.Compact(&profile, content); err != nil {
+			return "", fmt.Errorf("invalid seccomp profile %s: %s", setting, err)
+		}
+		return "seccomp=" + profile.String(), nil
+	default:
+		return "", fmt.Errorf("unknown security option %q, expected seccomp, apparmor, label or no-new-privileges", key)
+	}
+	return value, nil
+}
+
+// Apply sets the security options on the host configuration of a container.
+func (securityOptions SecurityOptions) Apply(hostConfig *docker.HostConfig) {
+	hostConfig.CapDrop = securityOptions.CapDrop
+	hostConfig.CapAdd = securityOptions.CapAdd
+	hostConfig.SecurityOpt = securityOptions.SecurityOpt
+	hostConfig.Runtime = securityOptions.Runtime
+	if securityOptions.ReadOnly {
+		hostConfig.ReadonlyRootfs = true
+		hostConfig.Tmpfs = map[string]string{"/tmp": readOnlyTmpfs}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestApplySandbox(t *testing.T) {
+	strict := map[OptionType][]string{
+		Sandbox:      {"strict"},
+		CapDrop:      {"ALL"},
+		SecurityOpt:  {"no-new-privileges"},
+		ReadOnlyFlag: {""},
+		Network:      {"none"},
+		PidsLimit:    {"256"},
+	}
+	cases := []struct {
+		layers    []OptionLayer
+		want      map[OptionType][]string
+		wantError string
+	}{
+		{
+			[]OptionLayer{{commandLineOrigin, map[OptionType][]string{Timeout: {"10"}}}},
+			map[OptionType][]string{Timeout: {"10"}},
+			"",
+		},
+		{
+			[]OptionLayer{{commandLineOrigin, map[OptionType][]string{Sandbox: {"strict"}}}},
+			strict,
+			"",
+		},
+		{
+			[]OptionLayer{{commandLineOrigin, map[OptionType][]string{Sandbox: {"strict"}, Network: {"bridge"}, CapAdd: {"NET_RAW"}, ReadOnlyFlag: nil}}},
+			map[OptionType][]string{
+				Sandbox:      {"strict"},
+				CapDrop:      {"ALL"},
+				CapAdd:       {"NET_RAW"},
+				SecurityOpt:  {"no-new-privileges"},
+				ReadOnlyFlag: nil,
+				Network:      {"bridge"},
+				PidsLimit:    {"256"},
+			},
+			"",
+		},
+		{
+			[]OptionLayer{
+				{".dexecrc", map[OptionType][]string{Network: {"bridge"}, CapAdd: {"NET_RAW"}, SecurityOpt: {"seccomp=unconfined"}, ReadOnlyFlag: nil, Timeout: {"10"}}},
+				{"environment", map[OptionType][]string{PidsLimit: {"4096"}}},
+				{commandLineOrigin, map[OptionType][]string{Sandbox: {"strict"}}},
+			},
+			map[OptionType][]string{
+				Sandbox:      {"strict"},
+				CapDrop:      {"ALL"},
+				SecurityOpt:  {"no-new-privileges"},
+				ReadOnlyFlag: {""},
+				Network:      {"none"},
+				PidsLimit:    {"256"},
+				Timeout:      {"10"},
+			},
+			"",
+		},
+		{
+			[]OptionLayer{
+				{".dexecrc", map[OptionType][]string{Sandbox: {"strict"}}},
+				{"environment", map[OptionType][]string{Network: {"bridge"}}},
+			},
+			map[OptionType][]string{
+				Sandbox:      {"strict"},
+				CapDrop:      {"ALL"},
+				SecurityOpt:  {"no-new-privileges"},
+				ReadOnlyFlag: {""},
+				Network:      {"bridge"},
+				PidsLimit:    {"256"},
+			},
+			"",
+		},
+		{
+			[]OptionLayer{
+				{".dexecrc", map[OptionType][]string{Sandbox: {"strict"}, Network: {"bridge"}}},
+				{commandLineOrigin, map[OptionType][]string{Sandbox: {"none"}}},
+			},
+			map[OptionType][]string{Sandbox: {"none"}, Network: {"bridge"}},
+			"",
+		},
+		{[]OptionLayer{{commandLineOrigin, map[OptionType][]string{Sandbox: {"paranoid"}}}}, nil, `unknown sandbox "paranoid", expected one of none, strict`},
+	}
+	for _, c := range cases {
+		layers, err := ApplySandbox(c.layers)
+		if c.wantError != "" {
+			if err == nil || err.Error() != c.wantError {
+				t.Errorf("ApplySandbox(%v) %v != %q", c.layers, err, c.wantError)
+			}
+			continue
+		}
+		got, _ := MergeOptions(layers...)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ApplySandbox(%v) %v, %v != %v", c.layers, got, err, c.want)
+		}
+	}
+
+	layers, _ := ApplySandbox([]OptionLayer{{commandLineOrigin, map[OptionType][]string{Sandbox: {"strict"}}}})
+	if _, origins := MergeOptions(layers...); origins[Network] != "sandbox strict" {
+		t.Errorf("ApplySandbox() network origin %q != %q", origins[Network], "sandbox strict")
+	}
+}
+
+func TestSecurityOptionsFromOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	profile := filepath.Join(dir, "seccomp.json")
+	ioutil.WriteFile(profile, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0644)
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalid, []byte("{"), 0644)
+
+	options := map[OptionType][]string{
+		CapDrop:      {"all"},
+		CapAdd:       {"net_bind_service", "CAP_CHOWN"},
+		SecurityOpt:  {"no-new-privileges", "seccomp=" + profile, "apparmor=dexec-default"},
+		ReadOnlyFlag: {""},
+		Runtime:      {"runsc"},
+	}
+	want := SecurityOptions{
+		CapDrop:     []string{"ALL"},
+		CapAdd:      []string{"NET_BIND_SERVICE", "CAP_CHOWN"},
+		SecurityOpt: []string{"no-new-privileges", `seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`, "apparmor=dexec-default"},
+		ReadOnly:    true,
+		Runtime:     "runsc",
+	}
+	got, err := SecurityOptionsFromOptions(options)
+	if err != nil {
+		t.Fatalf("SecurityOptionsFromOptions() unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SecurityOptionsFromOptions() %+v != %+v", got, want)
+	}
+
+	cases := []struct {
+		options map[OptionType][]string
+		want    string
+	}{
+		{map[OptionType][]string{CapDrop: {"net raw"}}, `invalid capability "net raw", expected a name such as NET_RAW or ALL`},
+		{map[OptionType][]string{SecurityOpt: {"seccomp"}}, `invalid security option "seccomp", expected <key>=<value> or no-new-privileges`},
+		{map[OptionType][]string{SecurityOpt: {"no-new-privileges=yes"}}, `invalid security option "no-new-privileges=yes", expected no-new-privileges=true or false`},
+		{map[OptionType][]string{SecurityOpt: {"selinux=on"}}, `unknown security option "selinux", expected seccomp, apparmor, label or no-new-privileges`},
+		{map[OptionType][]string{SecurityOpt: {"seccomp=" + invalid}}, "invalid seccomp profile " + invalid + ": unexpected end of JSON input"},
+		{map[OptionType][]string{Runtime: {"run sc"}}, `invalid runtime "run sc"`},
+	}
+	for _, c := range cases {
+		if _, err := SecurityOptionsFromOptions(c.options); err == nil || err.Error() != c.want {
+			t.Errorf("SecurityOptionsFromOptions(%v) %v != %q", c.options, err, c.want)
+		}
+	}
+	if _, err := SecurityOptionsFromOptions(map[OptionType][]string{SecurityOpt: {"seccomp=unconfined"}}); err != nil {
+		t.Errorf("SecurityOptionsFromOptions() seccomp=unconfined unexpected error %v", err)
+	}
+	if _, err := SecurityOptionsFromOptions(map[OptionType][]string{SecurityOpt: {"seccomp=" + filepath.Join(dir, "missing.json")}}); err == nil {
+		t.Errorf("SecurityOptionsFromOptions() expected error for a missing seccomp profile")
+	}
+}
+
+func TestSecurityOptionsApply(t *testing.T) {
+	hostConfig := &docker.HostConfig{Binds: []string{"/src/foo.c:/tmp/dexec/build/foo.c"}}
+	SecurityOptions{
+		CapDrop:     []string{"ALL"},
+		SecurityOpt: []string{"no-new-privileges"},
+		ReadOnly:    true,
+		Runtime:     "runsc",
+	}.Apply(hostConfig)
+	want := &docker.HostConfig{
+		Binds:          []string{"/src/foo.c:/tmp/dexec/build/foo.c"},
+		CapDrop:        []string{"ALL"},
+		SecurityOpt:    []string{"no-new-privileges"},
+		ReadonlyRootfs: true,
+		Tmpfs:          map[string]string{"/tmp": readOnlyTmpfs},
+		Runtime:        "runsc",
+	}
+	if !reflect.DeepEqual(hostConfig, want) {
+		t.Errorf("Apply() %+v != %+v", hostConfig, want)
+	}
+
+	writable := &docker.HostConfig{}
+	SecurityOptions{}.Apply(writable)
+	if !reflect.DeepEqual(writable, &docker.HostConfig{}) {
+		t.Errorf("Apply() without options %+v", writable)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const updateStateFilename = "update-checks.json"
+
+// ImageUpdatePolicy says how often dexec checks whether a newer image is
+// available for a tag in its registry.
+type ImageUpdatePolicy string
+
+const (
+	// UpdateNever means images are only pulled when missing or when asked to
+	// with --update.
+	UpdateNever ImageUpdatePolicy = "never"
+
+	// UpdateDaily means images are checked once a day.
+	UpdateDaily ImageUpdatePolicy = "daily"
+
+	// UpdateWeekly means images are checked once a week.
+	UpdateWeekly ImageUpdatePolicy = "weekly"
+
+	// UpdateAlways means images are checked on every run.
+	UpdateAlways ImageUpdatePolicy = "always"
+)
+
+// ParseUpdatePolicy returns the update policy with the given name, or
+// UpdateNever if the name is empty.
+func ParseUpdatePolicy(name string) (ImageUpdatePolicy, error) {
+	switch policy := ImageUpdatePolicy(strings.ToLower(name)); policy {
+	case "":
+		return UpdateNever, nil
+	case UpdateNever, UpdateDaily, UpdateWeekly, UpdateAlways:
+		return policy, nil
+	default:
+		return UpdateNever, fmt.Errorf("invalid update policy %q, expected never, daily, weekly or always", name)
+	}
+}
+
+// Due reports whether an image last checked at the given time should be
+// checked again now.
+func (policy ImageUpdatePolicy) Due(checked time.Time, now time.Time) bool {
+	switch policy {
+	case UpdateAlways:
+		return true
+	case UpdateDaily:
+		return now.Sub(checked) >= 24*time.Hour
+	case UpdateWeekly:
+		return now.Sub(checked) >= 7*24*time.Hour
+	default:
+		return false
+	}
+}
+
+// ChecksImage reports whether the policy ever checks the image for updates.
+// Images pinned to a digest are never checked, and nothing is checked
+// offline.
+func (policy ImageUpdatePolicy) ChecksImage(image *ContainerImage, pullOptions PullOptions) bool {
+	return policy != UpdateNever && !pullOptions.Offline && image.Digest == ""
+}
+
+// UpdateState records when each image was last checked for updates. It is
+// kept in a file in the dexec state directory.
+type UpdateState struct {
+	Checked map[string]time.Time `json:"checked"`
+	changed bool
+}
+
+// LoadUpdateState reads the update state file, returning an empty state if
+// there isn't one yet.
+func LoadUpdateState(filename string) (*UpdateState, error) {
+	state := &UpdateState{Checked: map[string]time.Time{}}
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if state.Checked == nil {
+		state.Checked = map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// Save writes the update state file if any check times have changed.
+func (state *UpdateState) Save(filename string) error {
+	if !state.changed {
+		return nil
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(content, '\n'), 0644)
+}
+
+func (state *UpdateState) record(reference string, now time.Time) {
+	state.Checked[reference] = now
+	state.changed = true
+}
+
+// RefreshImage fetches an image as FetchImage does, but also checks an image
+// that is present locally for updates when the policy says a check is due.
+// The digest of the local image is compared with the one in the registry and
+// the image is pulled again only if they differ. A one-line notice is written
+// when an image is updated or cannot be checked, in which case the local
+// image is used. Images pinned to a digest are never checked, and a local
+// image for another platform than the one asked for is replaced as it is by
+// FetchImage. The registry is asked for the digest with the credentials the
+// image would be pulled with.
+func RefreshImage(image *ContainerImage, pullOptions PullOptions, policy ImageUpdatePolicy, state *UpdateState, notices io.Writer, now time.Time, client *docker.Client) (*ContainerImage, error) {
+	if !policy.ChecksImage(image, pullOptions) {
+		return FetchImage(image, pullOptions, false, client)
+	}
+
+	local, inspected, err := FindLocalImage(image, pullOptions.Mirrors, client)
+	if err != nil {
+		return nil, err
+	} else if local == nil || !MatchesPlatform(inspected, pullOptions.platformFor(image)) {
+		fetched, err := FetchImage(image, pullOptions, false, client)
+		if err == nil {
+			state.record(fetched.Reference(), now)
+		}
+		return fetched, err
+	}
+
+	reference := local.Reference()
+	if !policy.Due(state.Checked[reference], now) {
+		return local, nil
+	}
+
+	digest, err := remoteDigest(local, pullOptions.Credentials, client)
+	if err != nil {
+		fmt.Fprintf(notices, "dexec: unable to check %s for updates, using the local image: %s\n", reference, err)
+		return local, nil
+	}
+	state.record(reference, now)
+
+	for _, repoDigest := range inspected.RepoDigests {
+		if strings.HasSuffix(repoDigest, "@"+digest) {
+			return local, nil
+		}
+	}
+
+	fmt.Fprintf(notices, "dexec: %s is out of date, pulling %s\n", reference, ShortDigest(digest))
+	updated, err := FetchImage(local, pullOptions, true, client)
+	if err != nil {
+		fmt.Fprintf(notices, "dexec: unable to update %s, using the local image: %s\n", reference, err)
+		return local, nil
+	}
+	return updated, nil
+}
+
+// remoteDigest returns the digest of an image's tag in its registry. The
+// Docker client has no way of passing credentials to the distribution API,
+// so for registries that need them the request is made through a transport
+// that adds them.
+func remoteDigest(image *ContainerImage, credentials *Credentials, client *docker.Client) (string, error) {
+	auth, err := credentials.Lookup(image.Image)
+	if err != nil {
+		return "", err
+	}
+	if auth != (docker.AuthConfiguration{}) {
+		content, err := json.Marshal(auth)
+		if err != nil {
+			return "", err
+		}
+		transport := client.HTTPClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		httpClient := *client.HTTPClient
+		httpClient.Transport = &registryAuthTransport{base64.URLEncoding.EncodeToString(content), transport}
+		authenticated := *client
+		authenticated.HTTPClient = &httpClient
+		client = &authenticated
+	}
+
+	remote, err := client.InspectDistribution(image.Reference())
+	if err != nil {
+		return "", err
+	}
+	return string(remote.Descriptor.Digest), nil
+}
+
+// registryAuthTransport adds encoded registry credentials to the requests
+// made to the Docker API through it.
+type registryAuthTransport struct {
+	auth      string
+	transport http.RoundTripper
+}
+
+func (t *registryAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	authenticated := *request
+	authenticated.Header = http.Header{}
+	for key, values := range request.Header {
+		authenticated.Header[key] = values
+	}
+	authenticated.Header.Set("X-Registry-Auth", t.auth)
+	return t.transport.RoundTrip(&authenticated)
+}
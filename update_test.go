@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const testRemoteDigest = "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+
+func TestParseUpdatePolicy(t *testing.T) {
+	cases := []struct {
+		name       string
+		wantPolicy ImageUpdatePolicy
+		wantError  bool
+	}{
+		{"", UpdateNever, false},
+		{"never", UpdateNever, false},
+		{"Daily", UpdateDaily, false},
+		{"weekly", UpdateWeekly, false},
+		{"always", UpdateAlways, false},
+		{"hourly", UpdateNever, true},
+	}
+	for _, c := range cases {
+		gotPolicy, err := ParseUpdatePolicy(c.name)
+		if (err != nil) != c.wantError {
+			t.Errorf("ParseUpdatePolicy(%q) unexpected error %v", c.name, err)
+		} else if gotPolicy != c.wantPolicy {
+			t.Errorf("ParseUpdatePolicy(%q) %q != %q", c.name, gotPolicy, c.wantPolicy)
+		}
+	}
+}
+
+func TestUpdatePolicyDue(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		policy  ImageUpdatePolicy
+		checked time.Time
+		wantDue bool
+	}{
+		{UpdateNever, time.Time{}, false},
+		{UpdateAlways, now, true},
+		{UpdateDaily, time.Time{}, true},
+		{UpdateDaily, now.Add(-23 * time.Hour), false},
+		{UpdateDaily, now.Add(-25 * time.Hour), true},
+		{UpdateWeekly, now.Add(-6 * 24 * time.Hour), false},
+		{UpdateWeekly, now.Add(-8 * 24 * time.Hour), true},
+	}
+	for _, c := range cases {
+		if gotDue := c.policy.Due(c.checked, now); gotDue != c.wantDue {
+			t.Errorf("%s.Due(%s) %t != %t", c.policy, c.checked, gotDue, c.wantDue)
+		}
+	}
+}
+
+func TestUpdatePolicyChecksImage(t *testing.T) {
+	image := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"}
+	pinned := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2", Digest: testDigest}
+	cases := []struct {
+		policy      ImageUpdatePolicy
+		image       *ContainerImage
+		pullOptions PullOptions
+		want        bool
+	}{
+		{UpdateNever, image, PullOptions{}, false},
+		{UpdateDaily, image, PullOptions{}, true},
+		{UpdateAlways, image, PullOptions{Offline: true}, false},
+		{UpdateAlways, pinned, PullOptions{}, false},
+	}
+	for _, c := range cases {
+		if got := c.policy.ChecksImage(c.image, c.pullOptions); got != c.want {
+			t.Errorf("%s.ChecksImage(%q, %+v) %t != %t", c.policy, c.image.Reference(), c.pullOptions, got, c.want)
+		}
+	}
+}
+
+func TestUpdateStateSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "state", updateStateFilename)
+
+	state, err := LoadUpdateState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	state.record("dexec/lang-c:1.0.2", now)
+	if err := state.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadUpdateState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Checked["dexec/lang-c:1.0.2"].Equal(now) {
+		t.Errorf("LoadUpdateState() %v != %v", loaded.Checked, state.Checked)
+	}
+}
+
+func TestRefreshImage(t *testing.T) {
+	now := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		checked         time.Time
+		remoteStatus    int
+		remoteDigest    string
+		wantPull        bool
+		wantInspections int
+		wantRecorded    bool
+		wantNotice      string
+	}{
+		{time.Time{}, http.StatusOK, testDigest, false, 1, true, ""},
+		{time.Time{}, http.StatusOK, testRemoteDigest, true, 1, true, "dexec: dexec/lang-c:1.0.2 is out of date, pulling sha256:fedcba987654\n"},
+		{now.Add(-time.Hour), http.StatusOK, testRemoteDigest, false, 0, false, ""},
+		{time.Time{}, http.StatusUnauthorized, "", false, 1, false, "dexec: unable to check dexec/lang-c:1.0.2 for updates, using the local image"},
+	}
+	for _, c := range cases {
+		var pulls, inspections int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.Contains(r.URL.Path, "/distribution/"):
+				inspections++
+				if c.remoteStatus != http.StatusOK {
+					http.Error(w, `{"message":"authentication required"}`, c.remoteStatus)
+					return
+				}
+				w.Write([]byte(`{"Descriptor":{"digest":"` + c.remoteDigest + `","size":1234}}`))
+			case strings.HasSuffix(r.URL.Path, "/images/create"):
+				pulls++
+				w.Write([]byte(`{"status":"Downloaded newer image"}` + "\r\n"))
+			case strings.HasSuffix(r.URL.Path, "/json"):
+				w.Write([]byte(`{"Id":"sha256:abc","RepoDigests":["dexec/lang-c@` + testDigest + `"]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		client, err := docker.NewClient(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state := &UpdateState{Checked: map[string]time.Time{}}
+		if !c.checked.IsZero() {
+			state.Checked["dexec/lang-c:1.0.2"] = c.checked
+		}
+		var notices bytes.Buffer
+		image := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"}
+		got, err := RefreshImage(image, PullOptions{}, UpdateDaily, state, &notices, now, client)
+		server.Close()
+
+		if err != nil || got.Reference() != "dexec/lang-c:1.0.2" {
+			t.Errorf("RefreshImage() %v, %v", got, err)
+		}
+		if (pulls > 0) != c.wantPull {
+			t.Errorf("RefreshImage() with remote digest %s made %d pulls", c.remoteDigest, pulls)
+		}
+		if inspections != c.wantInspections {
+			t.Errorf("RefreshImage() checked the registry %d times, expected %d", inspections, c.wantInspections)
+		}
+		if recorded := state.Checked["dexec/lang-c:1.0.2"].Equal(now); recorded != c.wantRecorded {
+			t.Errorf("RefreshImage() recorded check %t != %t", recorded, c.wantRecorded)
+		}
+		if !strings.HasPrefix(notices.String(), c.wantNotice) || (c.wantNotice == "") != (notices.Len() == 0) {
+			t.Errorf("RefreshImage() notice %q != %q", notices.String(), c.wantNotice)
+		}
+	}
+}
+
+func TestRefreshImageNever(t *testing.T) {
+	var inspections int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/distribution/") {
+			inspections++
+		}
+		w.Write([]byte(`{"Id":"sha256:abc","RepoDigests":[]}`))
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"}
+	for _, policy := range []ImageUpdatePolicy{UpdateNever, UpdateAlways} {
+		state := &UpdateState{Checked: map[string]time.Time{}}
+		if _, err := RefreshImage(image, PullOptions{Offline: true}, policy, state, ioutil.Discard, time.Now(), client); err != nil {
+			t.Errorf("RefreshImage() offline with policy %s unexpected error %v", policy, err)
+		}
+	}
+	if inspections > 0 {
+		t.Errorf("RefreshImage() checked the registry %d times when it should not have", inspections)
+	}
+}
+
+func TestRefreshImageCredentials(t *testing.T) {
+	var auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/distribution/"):
+			auths = append(auths, r.Header.Get("X-Registry-Auth"))
+			w.Write([]byte(`{"Descriptor":{"digest":"` + testDigest + `","size":1234}}`))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			w.Write([]byte(`{"Id":"sha256:abc","RepoDigests":["registry.corp.local/lang-c@` + testDigest + `"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials := &Credentials{Overrides: map[string]docker.AuthConfiguration{
+		"registry.corp.local": {Username: "dexec", Password: "secret"},
+	}}
+	image := &ContainerImage{Name: "C", Image: "registry.corp.local/lang-c", Version: "1.0.2"}
+	state := &UpdateState{Checked: map[string]time.Time{}}
+	var notices bytes.Buffer
+	if _, err := RefreshImage(image, PullOptions{Credentials: credentials}, UpdateAlways, state, &notices, time.Now(), client); err != nil {
+		t.Fatalf("RefreshImage() unexpected error %v", err)
+	}
+	if notices.Len() > 0 {
+		t.Errorf("RefreshImage() notice %q", notices.String())
+	}
+	if len(auths) != 1 || auths[0] == "" {
+		t.Fatalf("RefreshImage() registry auth headers %q", auths)
+	}
+	decoded, _ := base64.URLEncoding.DecodeString(auths[0])
+	if !strings.Contains(string(decoded), `"username":"dexec"`) || !strings.Contains(string(decoded), `"serveraddress":"registry.corp.local"`) {
+		t.Errorf("RefreshImage() sent registry auth %s", decoded)
+	}
+
+	if _, err := RefreshImage(image, PullOptions{}, UpdateAlways, state, &notices, time.Now(), client); err != nil || auths[1] != "" {
+		t.Errorf("RefreshImage() without credentials sent registry auth %q, %v", auths[1:], err)
+	}
+}
+
+func TestRefreshImagePlatform(t *testing.T) {
+	var platforms []string
+	var inspections int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/distribution/"):
+			inspections++
+			w.Write([]byte(`{"Descriptor":{"digest":"` + testDigest + `","size":1234}}`))
+		case strings.HasSuffix(r.URL.Path, "/images/create"):
+			platforms = append(platforms, r.URL.Query().Get("platform"))
+			w.Write([]byte(`{"status":"Downloaded newer image"}` + "\r\n"))
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			w.Write([]byte(`{"Id":"sha256:abc","Os":"linux","Architecture":"amd64","RepoDigests":["dexec/lang-c@` + testDigest + `"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := &ContainerImage{Name: "C", Image: "dexec/lang-c", Version: "1.0.2"}
+	state := &UpdateState{Checked: map[string]time.Time{}}
+	if _, err := RefreshImage(image, PullOptions{Platform: "linux/arm64"}, UpdateAlways, state, ioutil.Discard, time.Now(), client); err != nil {
+		t.Fatalf("RefreshImage() unexpected error %v", err)
+	}
+	if want := []string{"linux/arm64"}; len(platforms) != 1 || platforms[0] != want[0] {
+		t.Errorf("RefreshImage() for another platform pulled %q, expected %q", platforms, want)
+	}
+	if inspections > 0 {
+		t.Errorf("RefreshImage() checked an image for another platform %d times", inspections)
+	}
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+	"unicode"
 )
 
 const sanitisedWindowsPathPattern = "/%s%s"
@@ -66,15 +69,101 @@ func JoinStringSlices(slices ...[]string) []string {
 }
 
 // ExtractFileExtension extracts the extension from a filename. This is defined
-// as the remainder of the string after the last '.'.
+// as the remainder of the file's name after the last '.', ignoring any
+// permission suffix, or the empty string if the name has no '.' in it apart
+// from the one starting a dotfile such as '.bashrc'.
 func ExtractFileExtension(filename string) string {
-	patternPermission := regexp.MustCompile(`.*\.(.*):.*`)
-	permissionMatch := patternPermission.FindStringSubmatch(filename)
-	if len(permissionMatch) > 0 {
-		return permissionMatch[1]
+	patternPermission := regexp.MustCompile(`:[^/.]*$`)
+	name := strings.TrimPrefix(path.Base(patternPermission.ReplaceAllString(filename, "")), ".")
+	return strings.TrimPrefix(path.Ext(name), ".")
+}
+
+// IsStdinPiped returns true if STDIN is redirected from a file or pipe
+// rather than connected to a terminal.
+func IsStdinPiped() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && (stat.Mode()&os.ModeCharDevice) == 0
+}
+
+// SplitArgs splits a string into arguments on whitespace in the same way as
+// a POSIX shell would, honouring single quotes, double quotes and backslash
+// escapes. Within double quotes a backslash only escapes '"', '\', '$' and
+// '`' and is otherwise kept.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var current bytes.Buffer
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	} else if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// ConfigDir returns the directory in which dexec looks for its user level
+// configuration. This is $XDG_CONFIG_HOME/dexec if set and ~/.config/dexec
+// otherwise.
+func ConfigDir() string {
+	if configHome := os.Getenv("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "dexec")
+	}
+	return filepath.Join(HomeDir(), ".config", "dexec")
+}
+
+// StateDir returns the directory in which dexec keeps state between runs.
+// This is $XDG_STATE_HOME/dexec if set and ~/.local/state/dexec otherwise.
+func StateDir() string {
+	if stateHome := os.Getenv("XDG_STATE_HOME"); stateHome != "" {
+		return filepath.Join(stateHome, "dexec")
+	}
+	return filepath.Join(HomeDir(), ".local", "state", "dexec")
+}
+
+// HomeDir returns the home directory of the current user.
+func HomeDir() string {
+	if runtime.GOOS == "windows" {
+		return os.Getenv("USERPROFILE")
 	}
-	patternFilename := regexp.MustCompile(`.*\.(.*)`)
-	return patternFilename.FindStringSubmatch(filename)[1]
+	return os.Getenv("HOME")
 }
 
 // WriteFile writes a file.
@@ -68,6 +68,14 @@ func TestExtractFileExtension(t *testing.T) {
 	}{
 		{"foo.bar", "bar"},
 		{"foo.bar.foobar", "foobar"},
+		{"foo.bar:ro", "bar"},
+		{"foo", ""},
+		{"./foo", ""},
+		{"foo:rw", ""},
+		{"dir.d/foo", ""},
+		{".bashrc", ""},
+		{"dir/.bashrc:ro", ""},
+		{".config.yml", "yml"},
 	}
 	for _, c := range cases {
 		gotExtension := ExtractFileExtension(c.filename)
@@ -76,3 +84,25 @@ func TestExtractFileExtension(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"-b -O2", []string{"-b", "-O2"}},
+		{"  -a   'hello world'  ", []string{"-a", "hello world"}},
+		{`-a "it's" -a it\'s -a ''`, []string{"-a", "it's", "-a", "it's", "-a", ""}},
+		{`-a "say \"hi\"" -a "C:\\dir"`, []string{"-a", `say "hi"`, "-a", `C:\dir`}},
+		{`-a "a\nb" -a 'a\nb' -a a\nb`, []string{"-a", `a\nb`, "-a", `a\nb`, "-a", "anb"}},
+	}
+	for _, c := range cases {
+		got, err := SplitArgs(c.line)
+		if err != nil {
+			t.Errorf("SplitArgs(%q) unexpected error %q", c.line, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("SplitArgs(%q) %q != %q", c.line, got, c.want)
+		}
+	}
+}